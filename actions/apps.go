@@ -0,0 +1,88 @@
+package actions
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+)
+
+// maxRefLookups bounds the GitHub requests made at once by the Apps page.
+const maxRefLookups = 4
+
+// Apps lists the apps the user can see that were deployed from a
+// repository, flagging those whose ref has moved on since.
+func Apps(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	foundation, authClient, err := ccClient(c, r)
+	if err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
+	apps, err := h.FetchDeployedApps(authClient, foundation, c.Config.ResultsPerPage)
+	if err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Apps deployed from the same ref share a lookup, and only a few run at
+	// once, since each counts against GitHub's rate limit.
+	refs := map[h.AppSource]bool{}
+	for _, app := range apps {
+		if app.Owner != "" && app.Repo != "" && app.Ref != "" {
+			refs[h.AppSource{Owner: app.Owner, Repo: app.Repo, Ref: app.Ref}] = true
+		}
+	}
+
+	client := h.NewGitHubClient()
+	latest := map[h.AppSource]string{}
+	lock := sync.Mutex{}
+	slots := make(chan struct{}, maxRefLookups)
+	wg := sync.WaitGroup{}
+	for source := range refs {
+		key := h.RefCacheKey(source.Owner, source.Repo, source.Ref)
+		if sha, ok := c.Refs.Get(key); ok {
+			lock.Lock()
+			latest[source] = sha
+			lock.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func(source h.AppSource, key string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			sha, _, err := client.Repositories.GetCommitSHA1(context.Background(), source.Owner, source.Repo, source.Ref, "")
+			if err != nil {
+				c.Log(r).WithError(err).WithField("ref", key).Warn("Error checking for newer commits")
+				return
+			}
+			c.Refs.Set(key, sha)
+			lock.Lock()
+			latest[source] = sha
+			lock.Unlock()
+		}(source, key)
+	}
+	wg.Wait()
+
+	for idx := range apps {
+		app := &apps[idx]
+		app.Latest = latest[h.AppSource{Owner: app.Owner, Repo: app.Repo, Ref: app.Ref}]
+	}
+
+	render(c, w, r, "apps", map[string]interface{}{
+		"Title": "Apps",
+		"Apps":  apps,
+	})
+}
+
+// ccClient returns the selected foundation and a client authorized as the
+// user on it.
+func ccClient(c *h.Context, r *http.Request) (h.Foundation, *http.Client, error) {
+	foundation, login, err := c.CurrentLogin(r)
+	if err != nil {
+		return h.Foundation{}, nil, err
+	}
+	return foundation, foundation.OauthConfig(c.Config.Hostname).Client(h.OauthContext(context.TODO(), "cc"), &login.Token), nil
+}
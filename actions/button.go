@@ -0,0 +1,88 @@
+package actions
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+
+	"github.com/gorilla/mux"
+)
+
+// Badge renders the deploy button as an SVG or PNG. The label, message and
+// colors can be set in the query; foundation=<name> shows that foundation's
+// label as the message.
+func Badge(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	badge := h.Badge{
+		Label:      valueOr(query.Get("label"), "Deploy to"),
+		Message:    valueOr(query.Get("message"), "Cloud Foundry"),
+		LabelColor: valueOr(query.Get("labelColor"), "555"),
+		Color:      valueOr(query.Get("color"), "0a6cb5"),
+	}
+	if foundation, ok := c.Foundation(query.Get("foundation")); ok && query.Get("message") == "" {
+		badge.Message = foundation.Label
+	}
+	if err := badge.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	// Render into a buffer, so that an error can still set the status.
+	buf := bytes.Buffer{}
+	contentType := "image/svg+xml"
+	var err error
+	if mux.Vars(r)["format"] == "png" {
+		contentType = "image/png"
+		err = badge.PNG(&buf)
+	} else {
+		err = badge.SVG(&buf)
+	}
+	if err != nil {
+		c.Log(r).WithError(err).Error("Error rendering badge")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Content-Type", contentType)
+	buf.WriteTo(w)
+}
+
+// Button shows README snippets for a repository's deploy button.
+func Button(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	params := url.Values{}
+	for _, key := range []string{"owner", "repo", "ref"} {
+		if query.Get(key) == "" {
+			renderMessage(c, w, r, http.StatusBadRequest, "owner, repo and ref are required")
+			return
+		}
+		params.Set(key, query.Get(key))
+	}
+
+	badgeURL := c.Config.Hostname + "/button.svg"
+	if foundation := query.Get("foundation"); foundation != "" {
+		badgeURL += "?" + url.Values{"foundation": {foundation}}.Encode()
+	}
+	deployURL := c.Config.Hostname + "/?" + params.Encode()
+
+	render(c, w, r, "button", map[string]interface{}{
+		"Title":     "Button",
+		"BadgeURL":  badgeURL,
+		"DeployURL": deployURL,
+		"Markdown":  fmt.Sprintf("[![Deploy to Cloud Foundry](%s)](%s)", badgeURL, deployURL),
+		"HTML":      fmt.Sprintf(`<a href="%s"><img src="%s" alt="Deploy to Cloud Foundry"></a>`, deployURL, badgeURL),
+		"RST":       fmt.Sprintf(".. image:: %s\n   :alt: Deploy to Cloud Foundry\n   :target: %s", badgeURL, deployURL),
+	})
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -1,9 +1,232 @@
 package actions
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	pathpkg "path"
+	"strings"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+
+	"github.com/google/go-github/github"
+	"github.com/gorilla/csrf"
+	"golang.org/x/oauth2"
+)
+
 const LayoutPath string = "templates/layout.html"
 
+// Source is what to deploy: the repository at Ref, or a prebuilt artifact
+// (a release asset or a URL) pushed with the repository's manifest at Ref.
 type Source struct {
-	Owner string `schema:"owner,required"`
-	Repo  string `schema:"repo,required"`
-	Ref   string `schema:"ref,required"`
+	Owner    string `schema:"owner,required"`
+	Repo     string `schema:"repo,required"`
+	Ref      string `schema:"ref"`
+	Release  string `schema:"release"`
+	Asset    string `schema:"asset"`
+	Artifact string `schema:"artifact"`
+	Checksum string `schema:"checksum"`
+	SHA      string `schema:"sha"`
+}
+
+// Validate checks the combination of fields, defaulting the manifest ref to
+// the release tag.
+func (s *Source) Validate() error {
+	if s.Ref == "" {
+		s.Ref = s.Release
+	}
+	switch {
+	case s.Ref == "":
+		return errors.New("A ref or release is required")
+	case s.Release != "" && s.Asset == "":
+		return errors.New("A release needs an asset")
+	case s.Release != "" && s.Artifact != "":
+		return errors.New("Choose either a release asset or an artifact URL")
+	case s.Artifact != "" && !strings.HasPrefix(s.Artifact, "https://"):
+		return errors.New("Artifact URLs must use https")
+	case s.Artifact != "" && s.Checksum == "":
+		return errors.New("An artifact URL needs a checksum")
+	case s.SHA != "" && !h.IsCommitSHA(s.SHA):
+		return errors.New("Invalid commit SHA")
+	}
+	return nil
+}
+
+// Resolve pins the source to the commit its ref points at now, so that the
+// form and the deployment see the same code even if a branch moves.
+func (s *Source) Resolve(client *github.Client) error {
+	if s.SHA != "" {
+		return nil
+	}
+	sha, _, err := client.Repositories.GetCommitSHA1(context.Background(), s.Owner, s.Repo, s.Ref, "")
+	if err != nil {
+		return err
+	}
+	s.SHA = sha
+	return nil
+}
+
+func (s Source) CommitURL() string {
+	return fmt.Sprintf("https://github.com/%s/%s/commit/%s", s.Owner, s.Repo, s.SHA)
+}
+
+func (s Source) IsArtifact() bool {
+	return s.Release != "" || s.Artifact != ""
+}
+
+// render executes templates/<name>.html inside the layout, adding the values
+// every page needs to data.
+func render(c *h.Context, w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	templates := template.Must(template.ParseFiles("templates/"+name+".html", LayoutPath))
+
+	data[csrf.TemplateTag] = csrf.TemplateField(r)
+	session, _ := c.Store.Get(r, "session")
+	if foundation, ok := c.SelectedFoundation(session); ok {
+		data["Foundation"] = foundation
+		data["User"] = h.GetLogins(session)[foundation.Name].User
+	}
+	data["Foundations"] = c.Foundations
+
+	templates.ExecuteTemplate(w, "base", data)
+}
+
+// renderError shows err to the user. Cloud Controller errors get an
+// actionable message, and an expired token also ends the foundation's login
+// so that the next request signs in again.
+func renderError(c *h.Context, w http.ResponseWriter, r *http.Request, status int, err error) {
+	c.Log(r).WithError(err).Error("Request failed")
+
+	message := "Something went wrong. Please try again later."
+	signIn := false
+	if ccErr, ok := err.(*h.CCError); ok {
+		message = ccErr.Message()
+		if ccErr.Unauthorized() {
+			status = http.StatusUnauthorized
+			signIn = true
+		} else if ccErr.StatusCode >= 400 && ccErr.StatusCode < 500 {
+			status = ccErr.StatusCode
+		}
+	} else if ghErr, ok := err.(*github.ErrorResponse); ok {
+		message = "GitHub: " + ghErr.Message
+		if ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusNotFound {
+			message = "The repository, ref or manifest.yml could not be found on GitHub."
+			status = http.StatusNotFound
+		}
+	} else if urlErr, ok := err.(*url.Error); ok {
+		if _, ok := urlErr.Err.(*oauth2.RetrieveError); ok {
+			message = "Your session has expired. Please sign in again."
+			status = http.StatusUnauthorized
+			signIn = true
+		}
+	} else if err == h.ErrNoUserID {
+		// Signing in again would get a token of the same kind.
+		message = "Your access token has no user ID, so your spaces can't be listed. Ask an administrator to check the UAA client."
+		status = http.StatusForbidden
+	}
+
+	if signIn {
+		session, _ := c.Store.Get(r, "session")
+		if foundation, ok := c.SelectedFoundation(session); ok {
+			delete(h.GetLogins(session), foundation.Name)
+			session.Values["redirect"] = r.URL.String()
+			session.Save(r, w)
+		}
+	}
+
+	w.WriteHeader(status)
+	render(c, w, r, "error", map[string]interface{}{
+		"Title":   "Error",
+		"Message": message,
+		"SignIn":  signIn,
+	})
+}
+
+// checkPolicy renders a rejection and returns false when the operator's
+// source policy forbids deploying source.
+func checkPolicy(c *h.Context, w http.ResponseWriter, r *http.Request, client *github.Client, source Source) bool {
+	err := c.Config.SourcePolicy().Check(client, source.Owner, source.Repo, source.Ref, source.SHA, source.Release, source.Artifact)
+	return allowed(c, w, r, err)
+}
+
+// verifySource renders a rejection and returns false when sources must be
+// verified and this one can't be. Whatever is downloaded must then match the
+// verified checksum, which replaces the source's own.
+func verifySource(c *h.Context, w http.ResponseWriter, r *http.Request, client *github.Client, source *Source) bool {
+	if c.Verifier == nil {
+		return true
+	}
+
+	var err error
+	var checksum, release, name string
+	switch c.Verifier.Mode {
+	case h.VerifySignatures:
+		switch {
+		case source.Artifact != "":
+			err = &h.PolicyError{Reason: "Artifact URLs can't be verified here; deploy an asset of a signed release instead."}
+		case source.Release != "" && source.Release != source.Ref:
+			err = &h.PolicyError{Reason: "Release assets must be deployed with the manifest from the same release."}
+		default:
+			err = c.Verifier.VerifySignature(client, source.Owner, source.Repo, source.Ref, source.SHA)
+			if err == nil && source.Release != "" {
+				release, name = source.Release, source.Asset
+				checksum, err = c.Verifier.SignedChecksum(client, source.Owner, source.Repo, release, name)
+			}
+		}
+	case h.VerifyChecksums:
+		// The tarballs GitHub generates aren't stable, so the published
+		// checksum only means anything for the one attached to the release.
+		if !source.IsArtifact() {
+			source.Release, source.Asset = source.Ref, h.TarballName(source.Repo, source.Ref)
+		}
+		release, name = source.Release, source.Asset
+		if source.Artifact != "" {
+			release, name = source.Ref, pathpkg.Base(source.Artifact)
+		}
+		checksum, err = c.Verifier.PublishedChecksum(client, source.Owner, source.Repo, release, name)
+		if err == nil && source.Artifact == "" {
+			var found bool
+			found, err = h.HasReleaseAsset(client, source.Owner, source.Repo, release, name)
+			if err == nil && !found {
+				err = &h.PolicyError{Reason: fmt.Sprintf("Release %s of %s/%s has no %s to deploy.", release, source.Owner, source.Repo, name)}
+			}
+		}
+	}
+
+	given := strings.ToLower(strings.TrimPrefix(source.Checksum, "sha256:"))
+	if err == nil && checksum != "" && given != "" && given != strings.TrimPrefix(checksum, "sha256:") {
+		err = &h.PolicyError{Reason: fmt.Sprintf("The checksum given for %s doesn't match the one published with release %s.", name, release)}
+	}
+	if checksum != "" {
+		source.Checksum = checksum
+	}
+	return allowed(c, w, r, err)
+}
+
+// allowed renders a rejection for a PolicyError, or an error page for any
+// other error, and returns whether err was nil.
+func allowed(c *h.Context, w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+	if policyErr, ok := err.(*h.PolicyError); ok {
+		w.WriteHeader(http.StatusForbidden)
+		render(c, w, r, "rejected", map[string]interface{}{
+			"Title":  "Not permitted",
+			"Reason": policyErr.Reason,
+		})
+		return false
+	}
+	renderError(c, w, r, http.StatusInternalServerError, err)
+	return false
+}
+
+func renderMessage(c *h.Context, w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.WriteHeader(status)
+	render(c, w, r, "error", map[string]interface{}{
+		"Title":   "Error",
+		"Message": message,
+	})
 }
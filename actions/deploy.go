@@ -2,20 +2,22 @@ package actions
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
-	"mime"
 	"net/http"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	h "github.com/jmcarp/deploy-to-cf/helpers"
 
 	"github.com/google/go-github/github"
 	"github.com/gorilla/schema"
-	"golang.org/x/oauth2"
+	log "github.com/sirupsen/logrus"
 )
 
 func Deploy(c *h.Context, w http.ResponseWriter, r *http.Request) {
@@ -25,24 +27,59 @@ func Deploy(c *h.Context, w http.ResponseWriter, r *http.Request) {
 	decoder := schema.NewDecoder()
 	decoder.IgnoreUnknownKeys(true)
 	if err := decoder.Decode(&source, r.Form); err != nil {
-		fmt.Println(err)
+		c.Log(r).WithError(err).Warn("Invalid deploy form")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if err := source.Validate(); err != nil {
+		renderMessage(c, w, r, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	target := strings.Split(r.Form.Get("target"), ":")
-	log.Println(target)
 	if len(target) != 4 {
-		log.Println("invalid target", target)
+		c.Log(r).WithField("target", r.Form.Get("target")).Warn("Invalid target")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	space, ok, err := findTarget(c, r, target[0], target[2])
+	if err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		renderMessage(c, w, r, http.StatusForbidden, "You can't deploy to that space.")
+		return
+	}
+	target[1], target[3] = space.Entity.OrgName, space.Entity.Name
+
+	// Once queued, the job finishes the metrics and cleans up.
+	h.DeploymentsStarted.Inc()
+	h.DeploymentsActive.Inc()
+	start := time.Now()
+	phase, queued := "source", false
+	defer func() {
+		if !queued {
+			h.DeploymentsActive.Dec()
+			h.FinishDeployment(start, phase, false)
+		}
+	}()
 
-	client := github.NewClient(nil)
-	app, err := h.LoadManifest(client, source.Owner, source.Repo, source.Ref)
+	client := h.NewGitHubClient()
+	if !checkPolicy(c, w, r, client, source) {
+		return
+	}
+	if err := source.Resolve(client); err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if !verifySource(c, w, r, client, &source) {
+		return
+	}
+
+	app, err := h.LoadManifest(client, c.Cache, source.Owner, source.Repo, source.SHA)
 	if err != nil {
-		log.Println(app, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		renderError(c, w, r, http.StatusInternalServerError, err)
 		return
 	}
 
@@ -54,18 +91,32 @@ func Deploy(c *h.Context, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if len(errors) > 0 {
-		log.Println(errors)
-		w.WriteHeader(http.StatusBadRequest)
+		renderMessage(c, w, r, http.StatusBadRequest, "Missing required environment variables: "+strings.Join(errors, ", "))
+		return
+	}
+
+	if strategy := r.Form.Get("strategy"); strategy != "" {
+		app.Strategy = strategy
+	}
+	if app.Strategy == "" {
+		app.Strategy = h.StrategyRecreate
+	}
+	if !h.ValidStrategy(app.Strategy) {
+		renderMessage(c, w, r, http.StatusBadRequest, fmt.Sprintf("Unknown deployment strategy %q", app.Strategy))
+		return
 	}
-	log.Println(app, errors)
 
 	dir, err := ioutil.TempDir("", "")
 	if err != nil {
-		log.Println(dir, err)
+		c.Log(r).WithError(err).Error("Error creating working directory")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	defer os.RemoveAll(dir)
+	defer func() {
+		if !queued {
+			os.RemoveAll(dir)
+		}
+	}()
 
 	envPath := filepath.Join(dir, "env")
 	appPath := filepath.Join(dir, "app")
@@ -73,30 +124,231 @@ func Deploy(c *h.Context, w http.ResponseWriter, r *http.Request) {
 	os.Mkdir(envPath, 0755)
 	os.Mkdir(appPath, 0755)
 
-	filename, err := download(client, appPath, source.Owner, source.Repo, source.Ref)
+	var rootPath, manifestPath string
+	if source.IsArtifact() {
+		rootPath, err = downloadArtifact(client, source, dir, appPath, c.Config.ArchiveLimits())
+		if err == nil {
+			manifestPath = filepath.Join(dir, "manifest.yml")
+			err = writeManifest(client, c.Cache, source, manifestPath)
+		}
+	} else {
+		rootPath, err = download(client, c.Cache, appPath, source.Owner, source.Repo, source.SHA, source.Checksum, c.Config.ArchiveLimits())
+		manifestPath = filepath.Join(rootPath, "manifest.yml")
+	}
 	if err != nil {
-		log.Println(dir, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		renderError(c, w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	tarPath := strings.TrimSuffix(filename, ".tar.gz")
-	manifestPath := filepath.Join(appPath, tarPath, "manifest.yml")
-
 	manifest, err := h.NewManifest(manifestPath)
+	if err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
 	for name, envvar := range app.EnvVars {
 		manifest.AddEnvironmentVariable(name, envvar.Value)
 	}
-	manifest.Save(manifestPath)
+	if guid := r.Form.Get("app"); guid != "" {
+		manifest, err = updateManifest(c, r, manifest, guid, target[2])
+		if !allowed(c, w, r, err) {
+			return
+		}
+	}
+	if err := manifest.Save(manifestPath); err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	phase = "policy"
+	if violations := c.Policy.Evaluate(app, manifest, target[1], target[3]); len(violations) > 0 {
+		w.WriteHeader(http.StatusForbidden)
+		render(c, w, r, "rejected", map[string]interface{}{
+			"Title":      "Not permitted",
+			"Violations": violations,
+		})
+		return
+	}
+
+	foundation, login, err := c.CurrentLogin(r)
+	if err != nil {
+		renderError(c, w, r, http.StatusUnauthorized, err)
+		return
+	}
 
 	session, _ := c.Store.Get(r, "session")
-	token := session.Values["token"].(oauth2.Token)
+	h.AddRecentSpace(session, target[2])
+	c.Targets.Invalidate(h.TargetCacheKey(foundation, login.User.ID))
+
+	deployment := h.NewID()
+	logger := c.Log(r).WithFields(log.Fields{
+		"deployment_id": deployment,
+		"user_id":       login.User.ID,
+		"source":        source.Owner + "/" + source.Repo,
+		"sha":           source.SHA,
+		"space_guid":    target[2],
+	})
+
+	event := h.AuditEvent{
+		Deployment: deployment,
+		UserID:     login.User.ID,
+		Email:      login.User.Email,
+		Foundation: foundation.Name,
+		Source:     source.Owner + "/" + source.Repo,
+		Ref:        source.Ref,
+		SHA:        source.SHA,
+		Artifact:   source.Artifact,
+		OrgGUID:    target[0],
+		SpaceGUID:  target[2],
+	}
+	if source.Release != "" {
+		event.Artifact = source.Release + "/" + source.Asset
+	}
+
+	// The record lets the status page follow the deployment, and a restarted
+	// instance tell which deployments it abandoned.
+	job := &deployJob{
+		c:      c,
+		logger: logger,
+		event:  event,
+		record: h.Deployment{
+			ID:         deployment,
+			Instance:   c.Config.Instance,
+			Status:     h.DeploymentQueued,
+			Phase:      "queued",
+			UserID:     login.User.ID,
+			Foundation: foundation.Name,
+			Owner:      source.Owner,
+			Repo:       source.Repo,
+			Ref:        source.Ref,
+			SHA:        source.SHA,
+			OrgGUID:    target[0],
+			OrgName:    target[1],
+			SpaceGUID:  target[2],
+			SpaceName:  target[3],
+			Strategy:   app.Strategy,
+			Started:    start,
+		},
+		cf:           h.NewCloudFoundry(foundation, login.Token, envPath, target[0], target[1], target[2], target[3]),
+		app:          app,
+		dir:          dir,
+		manifestPath: manifestPath,
+		rootPath:     rootPath,
+	}
+	job.save()
+	if err := c.Queue.Submit(job.run); err != nil {
+		job.record.Status, job.record.Error = h.DeploymentFailed, err.Error()
+		job.save()
+		renderMessage(c, w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	queued = true
+	logger.Info("Queued deployment")
+	c.Audit.Record(event.With(h.AuditDeployRequested, target[1]+"/"+target[3]))
+
+	h.AddHistory(session, h.HistoryEntry{
+		DeploymentID: deployment,
+		Owner:        source.Owner,
+		Repo:         source.Repo,
+		Ref:          source.Ref,
+		SHA:          source.SHA,
+		Foundation:   foundation.Label,
+		OrgName:      target[1],
+		SpaceName:    target[3],
+		Time:         time.Now(),
+	})
+	if err := session.Save(r, w); err != nil {
+		c.Log(r).WithError(err).Warn("Error saving session")
+	}
+
+	http.Redirect(w, r, "/deployments/"+deployment, http.StatusSeeOther)
+}
+
+// updateManifest points manifest at the app being updated, so that the push
+// replaces it even if it has been renamed since it was deployed.
+func updateManifest(c *h.Context, r *http.Request, manifest h.Manifest, guid, spaceGUID string) (h.Manifest, error) {
+	foundation, authClient, err := ccClient(c, r)
+	if err != nil {
+		return manifest, err
+	}
+	deployed, err := h.FetchDeployedApp(authClient, foundation, guid)
+	if err != nil {
+		return manifest, err
+	}
+	if deployed.SpaceGUID != spaceGUID {
+		return manifest, &h.PolicyError{Reason: fmt.Sprintf("%s is not in the chosen space, so it can't be updated there.", deployed.Name)}
+	}
+	return manifest.ForExistingApp(deployed.Name)
+}
+
+// deployJob pushes a prepared deployment on a queue worker. Each job has its
+// own CF_HOME under dir, so concurrent jobs never share credentials or
+// targets.
+type deployJob struct {
+	c            *h.Context
+	logger       *log.Entry
+	event        h.AuditEvent
+	record       h.Deployment
+	cf           *h.CloudFoundry
+	app          h.App
+	dir          string
+	manifestPath string
+	rootPath     string
+}
+
+func (j *deployJob) save() {
+	j.record.Updated = time.Now()
+	if err := j.c.Deployments.Save(j.record); err != nil {
+		j.logger.WithError(err).Warn("Error saving deployment")
+	}
+}
 
-	cf := h.NewCloudFoundry(c.Config, token, envPath, target[0], target[1], target[2], target[3])
-	err = cf.WriteConfig()
+func (j *deployJob) run() {
+	defer h.DeploymentsActive.Dec()
 
-	route, err := cf.Create(app, manifestPath, filepath.Join(appPath, tarPath), c.Config.ServiceTimeout)
-	log.Println(route, err)
+	j.logger.Info("Deploying")
+	j.record.Status, j.record.Phase = h.DeploymentRunning, "services"
+	j.save()
+
+	j.cf.Logger = j.logger
+	j.cf.Source = &h.AppSource{
+		Owner: j.record.Owner,
+		Repo:  j.record.Repo,
+		Ref:   j.record.Ref,
+		SHA:   j.record.SHA,
+	}
+	j.cf.Notify = func(eventType, detail string) {
+		j.c.Audit.Record(j.event.With(eventType, detail))
+		if eventType == h.AuditServiceCreated {
+			j.record.Services = append(j.record.Services, detail)
+		}
+		j.record.Phase = j.cf.Phase()
+		j.save()
+	}
+
+	route, err := "", j.cf.WriteConfig()
+	if err == nil {
+		route, err = j.cf.Create(j.app, j.manifestPath, j.rootPath, j.c.Config.ServiceTimeout)
+		j.record.Phase = j.cf.Phase()
+	}
+	h.FinishDeployment(j.record.Started, j.record.Phase, err == nil)
+
+	j.record.Route = route
+	if err != nil {
+		j.record.Status, j.record.Error = h.DeploymentFailed, err.Error()
+		j.logger.WithError(err).Warn("Deployment failed")
+		j.c.Audit.Record(j.event.With(h.AuditDeployFailed, err.Error()))
+	} else {
+		j.record.Status = h.DeploymentSucceeded
+		j.logger.WithField("route", route).Info("Deployed")
+	}
+	j.save()
+
+	// The working directory holds the user's token; record that it's gone.
+	if err := os.RemoveAll(j.dir); err != nil {
+		j.c.Audit.Record(j.event.With(h.AuditCleanup, err.Error()))
+	} else {
+		j.c.Audit.Record(j.event.With(h.AuditCleanup, "removed "+j.dir))
+	}
 }
 
 func getArchiveURL(client *github.Client, user, repo, ref string) (string, error) {
@@ -108,18 +360,137 @@ func getArchiveURL(client *github.Client, user, repo, ref string) (string, error
 	return url.String(), nil
 }
 
-func download(client *github.Client, path, owner, repo, ref string) (string, error) {
-	url, err := getArchiveURL(client, owner, repo, ref)
+// download fetches the tarball of a commit and extracts it into path. With a
+// cache, the tarball is also stored there so that later deploys can skip
+// GitHub; the cache is best-effort, since it may evict the tarball at once.
+// When checksum is set, the tarball must match it.
+func download(client *github.Client, cache h.Cache, path, owner, repo, sha, checksum string, limits h.ArchiveLimits) (string, error) {
+	key := h.CacheKey("github", owner, repo, sha, "tarball")
+	if cache != nil {
+		if reader, err := cache.Get(key); err == nil {
+			defer reader.Close()
+			return extract(reader, "", path, checksum, limits)
+		}
+	}
+
+	url, err := getArchiveURL(client, owner, repo, sha)
+	if err != nil {
+		return "", err
+	}
 	resp, err := http.Get(url)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Downloading %s/%s@%s returned %s", owner, repo, sha, resp.Status)
+	}
+
+	if cache == nil {
+		return extract(resp.Body, resp.Header.Get("Content-Type"), path, checksum, limits)
+	}
 
-	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	body := io.Reader(resp.Body)
+	if limits.MaxSize > 0 {
+		body = h.LimitSize(body, limits.MaxSize)
+	}
+	spool, err := ioutil.TempFile("", "tarball")
 	if err != nil {
 		return "", err
 	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	if _, err := io.Copy(spool, body); err != nil {
+		return "", err
+	}
 
-	return params["filename"], h.Untar(resp.Body, path)
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	root, err := extract(spool, resp.Header.Get("Content-Type"), path, checksum, limits)
+	if err != nil {
+		return "", err
+	}
+
+	// Only a tarball that extracted and matched its checksum is worth keeping.
+	if _, err := spool.Seek(0, io.SeekStart); err == nil {
+		if err := cache.Put(key, spool); err != nil {
+			log.WithError(err).Warn("Error caching tarball")
+		}
+	}
+	return root, nil
+}
+
+func extract(reader io.Reader, contentType, path, checksum string, limits h.ArchiveLimits) (string, error) {
+	hash := sha256.New()
+	archive, err := h.OpenArchive(io.TeeReader(reader, hash), contentType)
+	if err != nil {
+		return "", err
+	}
+	root, err := archive.Extract(path, limits)
+	if err != nil || checksum == "" {
+		return root, err
+	}
+
+	// The archive may stop reading before the end of the stream.
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	if err := h.VerifyChecksum("The tarball", hash.Sum(nil), checksum); err != nil {
+		return "", err
+	}
+	return root, nil
+}
+
+// downloadArtifact fetches the release asset or artifact URL into dir and
+// unpacks it into path. Artifacts that aren't archives, like binaries, are
+// pushed as a directory holding just that file.
+func downloadArtifact(client *github.Client, source Source, dir, path string, limits h.ArchiveLimits) (string, error) {
+	var reader io.ReadCloser
+	var err error
+	name := source.Asset
+	if source.Release != "" {
+		reader, err = h.DownloadReleaseAsset(client, source.Owner, source.Repo, source.Release, source.Asset)
+	} else {
+		name = pathpkg.Base(source.Artifact)
+		reader, err = h.DownloadArtifact(source.Artifact)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	artifactPath := filepath.Join(dir, "artifact")
+	if err := os.Mkdir(artifactPath, 0755); err != nil {
+		return "", err
+	}
+	filename, err := h.SaveArtifact(reader, artifactPath, name, source.Checksum, limits.MaxSize)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	archive, err := h.OpenArchive(file, "")
+	if err == h.ErrUnknownArchive {
+		return h.Directory(artifactPath).Extract(path, limits)
+	}
+	if err != nil {
+		return "", err
+	}
+	return archive.Extract(path, limits)
+}
+
+// writeManifest saves the repository's manifest at the source ref, since
+// artifacts don't carry one.
+func writeManifest(client *github.Client, cache h.Cache, source Source, path string) error {
+	raw, err := h.FetchManifest(client, cache, source.Owner, source.Repo, source.SHA)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, raw, 0644)
 }
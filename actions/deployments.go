@@ -0,0 +1,54 @@
+package actions
+
+import (
+	"net/http"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+
+	"github.com/gorilla/mux"
+)
+
+// DeploymentStatus shows the progress of one of the user's deployments,
+// refreshing until it finishes.
+func DeploymentStatus(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	_, login, err := c.CurrentLogin(r)
+	if err != nil {
+		renderError(c, w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	deployment, err := c.Deployments.Get(mux.Vars(r)["id"])
+	if err == h.ErrDeploymentNotFound || (err == nil && !ownsDeployment(c, r, login, deployment)) {
+		renderMessage(c, w, r, http.StatusNotFound, "That deployment could not be found.")
+		return
+	}
+	if err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Title":      "Deployment",
+		"Deployment": deployment,
+	}
+	if !deployment.Finished() {
+		data["Refresh"] = 5
+	}
+	render(c, w, r, "deploy", data)
+}
+
+// ownsDeployment matches the deployment to the user by ID. Logins without an
+// id_token have no user ID, so those users only see the deployments listed
+// in their own session's history.
+func ownsDeployment(c *h.Context, r *http.Request, login h.Login, deployment h.Deployment) bool {
+	if login.User.ID != "" {
+		return deployment.UserID == login.User.ID
+	}
+	session, _ := c.Store.Get(r, "session")
+	for _, entry := range h.History(session) {
+		if entry.DeploymentID == deployment.ID {
+			return true
+		}
+	}
+	return false
+}
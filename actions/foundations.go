@@ -0,0 +1,13 @@
+package actions
+
+import (
+	"net/http"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+)
+
+func Foundations(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	render(c, w, r, "foundations", map[string]interface{}{
+		"Title": "Foundations",
+	})
+}
@@ -0,0 +1,47 @@
+package actions
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+)
+
+// Healthz answers as long as the process can serve requests.
+func Healthz(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz checks the dependencies a deployment needs, answering 503 if any
+// of them is unavailable.
+func Readyz(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	pages, err := filepath.Glob("templates/*.html")
+	if err != nil {
+		c.Log(r).WithError(err).Error("Error listing templates")
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"status": "error"})
+		return
+	}
+	for idx, page := range pages {
+		if page == LayoutPath {
+			pages = append(pages[:idx], pages[idx+1:]...)
+			break
+		}
+	}
+
+	ready, checks := c.Ready(pages, LayoutPath)
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,31 @@
+package actions
+
+import (
+	"net/http"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+)
+
+func History(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	session, _ := c.Store.Get(r, "session")
+	history := h.History(session)
+
+	// Entries only hold the deployment ID; the outcome comes from the store.
+	for idx := range history {
+		if history[idx].DeploymentID == "" {
+			continue
+		}
+		deployment, err := c.Deployments.Get(history[idx].DeploymentID)
+		if err != nil {
+			continue
+		}
+		history[idx].Status = deployment.Status
+		history[idx].Route = deployment.Route
+		history[idx].Error = deployment.Error
+	}
+
+	render(c, w, r, "history", map[string]interface{}{
+		"Title":   "History",
+		"History": history,
+	})
+}
@@ -1,51 +1,94 @@
 package actions
 
 import (
-	"context"
-	"html/template"
-	"log"
 	"net/http"
 
 	h "github.com/jmcarp/deploy-to-cf/helpers"
 
-	"github.com/google/go-github/github"
-	"github.com/gorilla/csrf"
 	"github.com/gorilla/schema"
-	"golang.org/x/oauth2"
 )
 
 func Index(c *h.Context, w http.ResponseWriter, r *http.Request) {
 	source := Source{}
-	if err := schema.NewDecoder().Decode(&source, r.URL.Query()); err != nil {
-		log.Println(err)
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+	if err := decoder.Decode(&source, r.URL.Query()); err != nil {
+		c.Log(r).WithError(err).Warn("Invalid query")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if err := source.Validate(); err != nil {
+		renderMessage(c, w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	client := h.NewGitHubClient()
+	if !checkPolicy(c, w, r, client, source) {
+		return
+	}
+	if err := source.Resolve(client); err != nil {
+		renderError(c, w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if !verifySource(c, w, r, client, &source) {
+		return
+	}
 
-	client := github.NewClient(nil)
-	app, err := h.LoadManifest(client, source.Owner, source.Repo, source.Ref)
+	app, err := h.LoadManifest(client, c.Cache, source.Owner, source.Repo, source.SHA)
 	if err != nil {
-		log.Println(app, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		renderError(c, w, r, http.StatusInternalServerError, err)
 		return
 	}
 
-	session, _ := c.Store.Get(r, "session")
-	token := session.Values["token"].(oauth2.Token)
-	authClient := c.OauthConfig.Client(context.TODO(), &token)
-	targets, err := h.FetchTargets(authClient, c.Config)
+	info, err := h.FetchRepoInfo(client, c.Cache, source.Owner, source.Repo, source.SHA)
+	if err != nil {
+		c.Log(r).WithError(err).Warn("Error fetching repository details")
+	}
+
+	targets, err := fetchTargets(c, r)
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		renderError(c, w, r, http.StatusInternalServerError, err)
 		return
 	}
+	if len(targets) == 0 {
+		renderMessage(c, w, r, http.StatusOK, "You have no spaces to deploy to. Ask an org manager to give you the SpaceDeveloper role in a space.")
+		return
+	}
+
+	// Updating an app starts from its space and environment.
+	var update *h.DeployedApp
+	spaceGUID := ""
+	if guid := r.URL.Query().Get("app"); guid != "" {
+		foundation, authClient, err := ccClient(c, r)
+		if err != nil {
+			renderError(c, w, r, http.StatusInternalServerError, err)
+			return
+		}
+		deployed, err := h.FetchDeployedApp(authClient, foundation, guid)
+		if err != nil {
+			renderError(c, w, r, http.StatusInternalServerError, err)
+			return
+		}
+		env, err := h.FetchAppEnv(authClient, foundation, guid)
+		if err != nil {
+			renderError(c, w, r, http.StatusInternalServerError, err)
+			return
+		}
+		for name, envvar := range app.EnvVars {
+			if value, ok := env[name]; ok {
+				envvar.Value = value
+			}
+		}
+		update, spaceGUID = &deployed, deployed.SpaceGUID
+	}
 
-	c.Templates = template.Must(template.ParseFiles("templates/index.html", LayoutPath))
-	c.Templates.ExecuteTemplate(w, "base", map[string]interface{}{
-		csrf.TemplateTag: csrf.TemplateField(r),
-		"App":            app,
-		"Source":         source,
-		"Targets":        targets,
-		"Title":          "Home",
+	render(c, w, r, "index", map[string]interface{}{
+		"App":       app,
+		"Repo":      info,
+		"Source":    source,
+		"Targets":   targets,
+		"Update":    update,
+		"SpaceGUID": spaceGUID,
+		"Title":     "Home",
 	})
 }
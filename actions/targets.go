@@ -0,0 +1,84 @@
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	h "github.com/jmcarp/deploy-to-cf/helpers"
+)
+
+// Targets lists the spaces the user can deploy to, grouped by org. The org
+// and space query parameters narrow the list, e.g. /targets?org=sandbox.
+func Targets(c *h.Context, w http.ResponseWriter, r *http.Request) {
+	targets, err := fetchTargets(c, r)
+	if err != nil {
+		c.Log(r).WithError(err).Error("Error fetching targets")
+		status := http.StatusInternalServerError
+		if ccErr, ok := err.(*h.CCError); ok {
+			status = ccErr.StatusCode
+		} else if err == h.ErrNoUserID {
+			status = http.StatusForbidden
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targets)
+}
+
+// findTarget looks up a space the user can deploy to by its GUIDs, so that
+// the org and space names checked against the policy are the real ones
+// rather than whatever the form said.
+func findTarget(c *h.Context, r *http.Request, orgGUID, spaceGUID string) (h.Space, bool, error) {
+	groups, err := fetchTargets(c, r)
+	if err != nil {
+		return h.Space{}, false, err
+	}
+	for _, group := range groups {
+		for _, space := range group.Spaces {
+			if space.Meta.GUID == spaceGUID && space.Entity.OrgGUID == orgGUID {
+				return space, true, nil
+			}
+		}
+	}
+	return h.Space{}, false, nil
+}
+
+func fetchTargets(c *h.Context, r *http.Request) ([]h.TargetGroup, error) {
+	foundation, login, err := c.CurrentLogin(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if login.User.ID == "" {
+		return nil, h.ErrNoUserID
+	}
+	key := h.TargetCacheKey(foundation, login.User.ID)
+	spaces, ok := c.Targets.Get(key)
+	if !ok {
+		authClient := foundation.OauthConfig(c.Config.Hostname).Client(h.OauthContext(context.TODO(), "cc"), &login.Token)
+		spaces, err = h.FetchTargets(authClient, foundation, login.User.ID, c.Config.ResultsPerPage)
+		if err != nil {
+			return nil, err
+		}
+		c.Targets.Set(key, spaces)
+	}
+
+	query := r.URL.Query()
+	spaces = h.FilterTargets(spaces, query.Get("org"), query.Get("space"))
+
+	allowed := []h.Space{}
+	for _, space := range spaces {
+		if c.Policy.SpaceAllowed(space.Entity.OrgName, space.Entity.Name) {
+			allowed = append(allowed, space)
+		}
+	}
+	spaces = allowed
+
+	session, _ := c.Store.Get(r, "session")
+	return h.GroupTargets(spaces, h.RecentSpaces(session)), nil
+}
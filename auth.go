@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"net/url"
+	"time"
 
 	. "github.com/jmcarp/deploy-to-cf/helpers"
 
@@ -17,18 +20,72 @@ func Contextify(c *Context, h ContextHandler) http.Handler {
 func RequireAuth(context *Context, handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		session, _ := context.Store.Get(r, "session")
-		if _, ok := session.Values["token"].(oauth2.Token); ok {
-			handler.ServeHTTP(w, r)
+		foundation, ok := context.SelectedFoundation(session)
+		if ok {
+			logins := GetLogins(session)
+			if logins[foundation.Name].Valid() {
+				handler.ServeHTTP(w, r)
+				return
+			}
+			delete(logins, foundation.Name)
+		}
+
+		session.Values["redirect"] = r.URL.String()
+		session.Save(r, w)
+		if ok {
+			http.Redirect(w, r, "/auth?foundation="+url.QueryEscape(foundation.Name), http.StatusFound)
 		} else {
-			session.Values["redirect"] = r.URL.String()
-			session.Save(r, w)
-			http.Redirect(w, r, "/auth", http.StatusFound)
+			http.Redirect(w, r, "/foundations", http.StatusFound)
+		}
+	})
+}
+
+// RequireBasicAuth protects handler with a username and password, unless
+// username is empty.
+func RequireBasicAuth(username, password string, handler http.Handler) http.Handler {
+	if username == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
 		}
+		handler.ServeHTTP(w, r)
 	})
 }
 
 func Auth(c *Context, w http.ResponseWriter, r *http.Request) {
 	session, _ := c.Store.Get(r, "session")
+	foundation, ok := c.Foundation(r.URL.Query().Get("foundation"))
+	if !ok {
+		foundation, ok = c.SelectedFoundation(session)
+	}
+	if !ok {
+		http.Redirect(w, r, "/foundations", http.StatusFound)
+		return
+	}
+
+	// Switching back to a foundation with a live token doesn't need another login.
+	if GetLogins(session)[foundation.Name].Valid() {
+		session.Values["foundation"] = foundation.Name
+		redirect, ok := session.Values["redirect"].(string)
+		if !ok {
+			redirect = c.Config.Hostname
+		}
+		delete(session.Values, "redirect")
+		if err := session.Save(r, w); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, redirect, http.StatusFound)
+		return
+	}
+
 	state, err := GenerateRandomString(32)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -36,13 +93,15 @@ func Auth(c *Context, w http.ResponseWriter, r *http.Request) {
 	}
 
 	session.Values["state"] = state
+	session.Values["auth_foundation"] = foundation.Name
 	err = session.Save(r, w)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, c.OauthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline), http.StatusFound)
+	oauthConfig := foundation.OauthConfig(c.Config.Hostname)
+	http.Redirect(w, r, oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOnline), http.StatusFound)
 }
 
 func Callback(c *Context, w http.ResponseWriter, r *http.Request) {
@@ -55,26 +114,109 @@ func Callback(c *Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	name, _ := session.Values["auth_foundation"].(string)
+	foundation, ok := c.Foundation(name)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	redirect, ok := session.Values["redirect"].(string)
 	if !ok {
 		redirect = c.Config.Hostname
 	}
 
-	token, err := c.OauthConfig.Exchange(oauth2.NoContext, code)
+	token, err := foundation.OauthConfig(c.Config.Hostname).Exchange(OauthContext(oauth2.NoContext, "uaa"), code)
 	if err != nil {
+		c.Log(r).WithError(err).Error("Error exchanging the authorization code")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	session.Values["token"] = *token
+	login := Login{
+		Token:   *token,
+		Expires: time.Now().Add(time.Duration(c.Config.SessionTimeout) * time.Second).Unix(),
+	}
+	// The user ID comes from the access token, which UAA always gives one;
+	// the id_token only adds the details shown to the user.
+	user, err := ParseAccessToken(token.AccessToken)
+	if err != nil {
+		c.Log(r).WithError(err).Warn("Invalid access token")
+	}
+	if idToken, ok := token.Extra("id_token").(string); ok {
+		details, err := ParseIDToken(idToken)
+		if err != nil {
+			c.Log(r).WithError(err).Warn("Invalid ID token")
+		}
+		if details.UserName != "" {
+			user.UserName = details.UserName
+		}
+		if details.Email != "" {
+			user.Email = details.Email
+		}
+	}
+	login.User = user
+
+	GetLogins(session)[foundation.Name] = login
+	session.Values["foundation"] = foundation.Name
 	delete(session.Values, "state")
+	delete(session.Values, "auth_foundation")
 	delete(session.Values, "redirect")
 
 	err = session.Save(r, w)
 	if err != nil {
+		c.Log(r).WithError(err).Error("Error saving session")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	Logins.WithLabelValues(foundation.Name).Inc()
+	c.Audit.Record(AuditEvent{
+		Type:       AuditLogin,
+		UserID:     login.User.ID,
+		Email:      login.User.Email,
+		Foundation: foundation.Name,
+	})
 	http.Redirect(w, r, redirect, http.StatusFound)
 }
+
+// Logout only answers POST requests, which carry a CSRF token, so that other
+// sites can't sign users out.
+func Logout(c *Context, w http.ResponseWriter, r *http.Request) {
+	session, _ := c.Store.Get(r, "session")
+	foundation, hasFoundation := c.SelectedFoundation(session)
+	logins := GetLogins(session)
+	for key := range session.Values {
+		delete(session.Values, key)
+	}
+
+	// Copy the options so that expiring this session doesn't touch the store defaults.
+	options := *session.Options
+	options.MaxAge = -1
+	session.Options = &options
+
+	err := session.Save(r, w)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for name, login := range logins {
+		c.Audit.Record(AuditEvent{
+			Type:       AuditLogout,
+			UserID:     login.User.ID,
+			Email:      login.User.Email,
+			Foundation: name,
+		})
+	}
+
+	redirect := c.Config.Hostname
+	if c.Config.UAALogout && hasFoundation {
+		params := url.Values{}
+		params.Set("redirect", c.Config.Hostname)
+		params.Set("client_id", foundation.ClientID)
+		redirect = foundation.AuthURL + "/logout.do?" + params.Encode()
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
@@ -3,4 +3,4 @@
 set -e
 
 mv "${HOME}/bin/cli" "${HOME}/bin/cf"
-deploy-to-cf
+exec deploy-to-cf
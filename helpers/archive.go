@@ -2,18 +2,247 @@ package helpers
 
 import (
 	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// ArchiveLimits bounds what extracting an untrusted archive may write.
+type ArchiveLimits struct {
+	MaxSize  int64
+	MaxFiles int
+}
+
+// extractor writes archive entries under dest, refusing anything that would
+// land outside it or exceed the limits.
+type extractor struct {
+	dest   string
+	limits ArchiveLimits
+	size   int64
+	files  int
+}
+
+func newExtractor(dest string, limits ArchiveLimits) (*extractor, error) {
+	dest, err := filepath.Abs(dest)
+	if err != nil {
+		return nil, err
+	}
+	dest, err = filepath.EvalSymlinks(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{dest: dest, limits: limits}, nil
+}
+
+// Archive is a source tree that can be laid out on disk for a push.
+type Archive interface {
+	// Extract unpacks the archive into dest and returns the directory
+	// holding its contents.
+	Extract(dest string, limits ArchiveLimits) (string, error)
+}
+
+var ErrUnknownArchive = errors.New("Unrecognized archive format")
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zipMagic   = []byte("PK\x03\x04")
 )
 
-func Untar(reader io.Reader, dest string) error {
-	gzipReader, err := gzip.NewReader(reader)
+// OpenArchive detects the archive format from the first bytes of reader,
+// falling back on contentType for plain tarballs.
+func OpenArchive(reader io.Reader, contentType string) (Archive, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(6)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return &tarArchive{buffered, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }}, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return &tarArchive{buffered, func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }}, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		return &tarArchive{buffered, func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) }}, nil
+	case bytes.HasPrefix(magic, zipMagic):
+		return &zipArchive{buffered}, nil
+	}
+
+	switch strings.Split(contentType, ";")[0] {
+	case "application/x-tar", "application/tar":
+		return &tarArchive{buffered, func(r io.Reader) (io.Reader, error) { return r, nil }}, nil
+	}
+	return nil, ErrUnknownArchive
+}
+
+type tarArchive struct {
+	reader     io.Reader
+	decompress func(io.Reader) (io.Reader, error)
+}
+
+func (a *tarArchive) Extract(dest string, limits ArchiveLimits) (string, error) {
+	reader, err := a.decompress(a.reader)
+	if err != nil {
+		return "", err
+	}
+	if err := untar(reader, dest, limits); err != nil {
+		return "", err
+	}
+	return contentRoot(dest)
+}
+
+type zipArchive struct {
+	reader io.Reader
+}
+
+// Extract spools the zip to a temporary file first, since reading its
+// central directory needs random access.
+func (a *zipArchive) Extract(dest string, limits ArchiveLimits) (string, error) {
+	spool, err := ioutil.TempFile("", "archive")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	reader := a.reader
+	if limits.MaxSize > 0 {
+		reader = io.LimitReader(reader, limits.MaxSize+1)
+	}
+	size, err := io.Copy(spool, reader)
+	if err != nil {
+		return "", err
+	}
+	if limits.MaxSize > 0 && size > limits.MaxSize {
+		return "", fmt.Errorf("Archive is larger than %d bytes", limits.MaxSize)
+	}
+
+	zipReader, err := zip.NewReader(spool, size)
+	if err != nil {
+		return "", err
+	}
+
+	e, err := newExtractor(dest, limits)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range zipReader.File {
+		if err := e.extractZipFile(file); err != nil {
+			return "", err
+		}
+	}
+	if err := e.checkSymlinks(); err != nil {
+		return "", err
+	}
+	return contentRoot(dest)
+}
+
+func (e *extractor) extractZipFile(file *zip.File) error {
+	mode := file.Mode()
+	if mode.IsDir() {
+		return e.mkdir(file.Name)
+	}
+
+	reader, err := file.Open()
 	if err != nil {
 		return err
 	}
-	tarReader := tar.NewReader(gzipReader)
+	defer reader.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(io.LimitReader(reader, 4096))
+		if err != nil {
+			return err
+		}
+		return e.symlink(file.Name, string(target))
+	}
+	if !mode.IsRegular() {
+		return nil
+	}
+	return e.writeFile(file.Name, mode, int64(file.UncompressedSize64), reader)
+}
+
+// Directory is a tree that is already on disk and is pushed as it is.
+type Directory string
+
+func (d Directory) Extract(dest string, limits ArchiveLimits) (string, error) {
+	e, err := newExtractor(string(d), limits)
+	if err != nil {
+		return "", err
+	}
+	err = filepath.Walk(e.dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == e.dest {
+			return err
+		}
+		if err := e.count(); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			e.size += info.Size()
+			if limits.MaxSize > 0 && e.size > limits.MaxSize {
+				return fmt.Errorf("Directory is larger than %d bytes", limits.MaxSize)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := e.checkSymlinks(); err != nil {
+		return "", err
+	}
+	return e.dest, nil
+}
+
+// LimitSize fails reads once more than max bytes have come from reader,
+// unlike io.LimitReader, which quietly truncates.
+func LimitSize(reader io.Reader, max int64) io.Reader {
+	return &sizeLimitReader{reader, max}
+}
+
+type sizeLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errors.New("Archive is too large")
+	}
+	return n, err
+}
+
+// contentRoot skips the single top-level directory that GitHub and GitLab
+// wrap their archives in.
+func contentRoot(dest string) (string, error) {
+	entries, err := ioutil.ReadDir(dest)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dest, entries[0].Name()), nil
+	}
+	return dest, nil
+}
+
+func untar(reader io.Reader, dest string, limits ArchiveLimits) error {
+	e, err := newExtractor(dest, limits)
+	if err != nil {
+		return err
+	}
+	tarReader := tar.NewReader(reader)
 
 	for {
 		header, err := tarReader.Next()
@@ -25,26 +254,262 @@ func Untar(reader io.Reader, dest string) error {
 			}
 		}
 
-		path := filepath.Join(dest, header.Name)
-		info := header.FileInfo()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(header.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = e.writeFile(header.Name, header.FileInfo().Mode(), header.Size, tarReader)
+		case tar.TypeSymlink:
+			err = e.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = e.link(header.Name, header.Linkname)
+		default:
+			// Pax headers, devices, fifos and the like have no place in an app.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return e.checkSymlinks()
+}
 
-		if info.IsDir() {
-			err = os.MkdirAll(path, info.Mode())
-			if err != nil {
-				return err
-			}
-		} else {
-			file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-			if err != nil {
-				return err
-			}
-			defer file.Close()
+// resolve maps an entry name onto a path inside dest. The deepest of its
+// ancestors that exists is resolved through any symlinks extracted so far and
+// must stay inside too; the missing ones below it will be plain directories.
+func (e *extractor) resolve(name string) (string, error) {
+	path := filepath.Join(e.dest, name)
+	if !e.contains(path) || path == e.dest {
+		return "", fmt.Errorf("Archive entry %q is outside the destination", name)
+	}
 
-			_, err = io.Copy(file, tarReader)
-			if err != nil {
-				return err
-			}
+	ancestor := filepath.Dir(path)
+	for {
+		_, err := os.Lstat(ancestor)
+		if err == nil {
+			break
 		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		ancestor = filepath.Dir(ancestor)
+	}
+
+	// A dangling symlink fails to resolve; nothing may be created through it.
+	resolved, err := filepath.EvalSymlinks(ancestor)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err != nil || !e.contains(resolved) {
+		return "", fmt.Errorf("Archive entry %q is outside the destination", name)
+	}
+	return path, nil
+}
+
+func (e *extractor) contains(path string) bool {
+	return path == e.dest || strings.HasPrefix(path, e.dest+string(filepath.Separator))
+}
+
+func (e *extractor) count() error {
+	e.files++
+	if e.limits.MaxFiles > 0 && e.files > e.limits.MaxFiles {
+		return fmt.Errorf("Archive has more than %d files", e.limits.MaxFiles)
+	}
+	return nil
+}
+
+// prepare creates the entry's parent directories and clears anything already
+// at its path, so that a new file is never written through an old symlink.
+func (e *extractor) prepare(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+func (e *extractor) mkdir(name string) error {
+	path, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := e.count(); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(path, 0755)
+}
+
+func (e *extractor) writeFile(name string, mode os.FileMode, size int64, reader io.Reader) error {
+	path, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := e.count(); err != nil {
+		return err
+	}
+	e.size += size
+	if e.limits.MaxSize > 0 && e.size > e.limits.MaxSize {
+		return fmt.Errorf("Archive is larger than %d bytes", e.limits.MaxSize)
+	}
+	if err := e.prepare(path); err != nil {
+		return err
+	}
+
+	// Keep only the executable bit; the archive doesn't get to pick setuid or world-writable files.
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(reader, size))
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("Archive entry %q is truncated", name)
+	}
+	return file.Close()
+}
+
+func (e *extractor) symlink(name, target string) error {
+	path, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(target) || !e.contains(filepath.Join(filepath.Dir(path), target)) {
+		return fmt.Errorf("Archive symlink %q points outside the destination", name)
+	}
+	if err := e.count(); err != nil {
+		return err
+	}
+	if err := e.prepare(path); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+
+	// A target that is inside lexically can still leave through links
+	// extracted earlier, e.g. s1 -> s0/.. where s0 -> .
+	if err := e.checkSymlink(path); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+func (e *extractor) link(name, target string) error {
+	path, err := e.resolve(name)
+	if err != nil {
+		return err
+	}
+	source, err := e.resolve(target)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("Archive hard link %q must point to a regular file", name)
+	}
+	if err := e.count(); err != nil {
+		return err
+	}
+	if err := e.prepare(path); err != nil {
+		return err
+	}
+	return os.Link(source, path)
+}
+
+// checkSymlinks resolves every extracted symlink again now that the whole
+// tree exists, since later entries may have changed where a link leads.
+func (e *extractor) checkSymlinks() error {
+	return filepath.Walk(e.dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		return e.checkSymlink(path)
+	})
+}
+
+func (e *extractor) checkSymlink(path string) error {
+	name := strings.TrimPrefix(path, e.dest+string(filepath.Separator))
+	inside, err := e.follow(name)
+	if err != nil {
+		return err
+	}
+	if !inside {
+		return fmt.Errorf("Archive symlink %q resolves outside the destination", name)
 	}
 	return nil
 }
+
+// maxSymlinks bounds the links followed while resolving one path.
+const maxSymlinks = 255
+
+// follow resolves name, relative to dest, one component at a time against
+// the tree on disk, and reports whether it stays inside dest. A missing
+// component is taken as written rather than accepted, so that a link which
+// dangles now can't leave dest once something is created where it points,
+// e.g. a sibling directory holding the user's cf config.
+func (e *extractor) follow(name string) (bool, error) {
+	current := []string{}
+	pending := strings.Split(name, string(filepath.Separator))
+	links := 0
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(current) == 0 {
+				return false, nil
+			}
+			current = current[:len(current)-1]
+			continue
+		}
+
+		path := filepath.Join(e.dest, filepath.Join(current...), part)
+		info, err := os.Lstat(path)
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = append(current, part)
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return false, fmt.Errorf("Archive symlink %q has too many levels of links", name)
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return false, err
+		}
+		if filepath.IsAbs(target) {
+			return false, nil
+		}
+		pending = append(strings.Split(target, string(filepath.Separator)), pending...)
+	}
+	return true, nil
+}
@@ -0,0 +1,218 @@
+package helpers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func tarball(t testing.TB, entries ...entry) []byte {
+	buf := bytes.Buffer{}
+	writer := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.body))}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			writer.Write([]byte(e.body))
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractInto extracts data into a fresh destination, failing the test if
+// anything was written outside it.
+func extractInto(t *testing.T, data []byte, limits ArchiveLimits) error {
+	parent, dest := tempDest(t)
+	defer os.RemoveAll(parent)
+
+	err := untar(bytes.NewReader(data), dest, limits)
+	assertContained(t, parent, dest)
+	return err
+}
+
+// assertContained checks that parent holds nothing but the directories
+// leading to dest, so that escapes of several levels are noticed too.
+func assertContained(t testing.TB, parent, dest string) {
+	err := filepath.Walk(parent, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dest && strings.HasPrefix(dest, path+string(filepath.Separator)) {
+			return nil
+		}
+		if path == dest {
+			return filepath.SkipDir
+		}
+		t.Fatalf("archive wrote %s outside the destination", path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tempDest creates a destination a few levels below a fresh parent.
+func tempDest(t testing.TB) (string, string) {
+	parent, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(parent, "a", "b", "dest")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return parent, dest
+}
+
+func TestUntarRejectsEscapes(t *testing.T) {
+	cases := map[string][]entry{
+		"parent path": {
+			{name: "../evil", typeflag: tar.TypeReg, body: "x"},
+		},
+		"absolute symlink": {
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"},
+		},
+		"relative symlink": {
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "../"},
+		},
+		"file through symlink": {
+			{name: "dir/", typeflag: tar.TypeDir},
+			{name: "dir/link", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "dir/link/link2", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "dir/link/link2/evil", typeflag: tar.TypeReg, body: "x"},
+		},
+		"chained symlinks": {
+			{name: "s0", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "s1", typeflag: tar.TypeSymlink, linkname: "s0/.."},
+			{name: "s2", typeflag: tar.TypeSymlink, linkname: "s1/.."},
+			{name: "s2/newdir/evil", typeflag: tar.TypeReg, body: "x"},
+		},
+		"new directory through chained symlinks": {
+			{name: "s0", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "s1", typeflag: tar.TypeSymlink, linkname: "s0/.."},
+			{name: "s1/newdir/", typeflag: tar.TypeDir},
+		},
+		"completed dangling symlink": {
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "dir/../.."},
+			{name: "dir/", typeflag: tar.TypeDir},
+		},
+		"dangling symlink to a sibling": {
+			{name: "repo/", typeflag: tar.TypeDir},
+			{name: "repo/s0", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "repo/s1", typeflag: tar.TypeSymlink, linkname: "s0/../env/.cf/config.json"},
+		},
+		"hard link outside": {
+			{name: "link", typeflag: tar.TypeLink, linkname: "../evil"},
+		},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			if err := extractInto(t, tarball(t, entries...), ArchiveLimits{}); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestUntarLimits(t *testing.T) {
+	data := tarball(t,
+		entry{name: "a", typeflag: tar.TypeReg, body: "aaaa"},
+		entry{name: "b", typeflag: tar.TypeReg, body: "bbbb"},
+	)
+	if err := extractInto(t, data, ArchiveLimits{MaxFiles: 1}); err == nil {
+		t.Error("expected the file limit to be enforced")
+	}
+	if err := extractInto(t, data, ArchiveLimits{MaxSize: 6}); err == nil {
+		t.Error("expected the size limit to be enforced")
+	}
+	if err := extractInto(t, data, ArchiveLimits{MaxFiles: 2, MaxSize: 8}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUntarKeepsSafeSymlinks(t *testing.T) {
+	data := tarball(t,
+		entry{name: "app/", typeflag: tar.TypeDir},
+		entry{name: "app/bin/", typeflag: tar.TypeDir},
+		entry{name: "app/bin/run", typeflag: tar.TypeReg, body: "#!/bin/sh"},
+		entry{name: "app/run", typeflag: tar.TypeSymlink, linkname: "bin/run"},
+		entry{name: "app/copy", typeflag: tar.TypeLink, linkname: "app/bin/run"},
+		entry{name: "app/config", typeflag: tar.TypeSymlink, linkname: "bin/../config.local"},
+	)
+	if err := extractInto(t, data, ArchiveLimits{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZipRejectsEscapes(t *testing.T) {
+	buf := bytes.Buffer{}
+	writer := zip.NewWriter(&buf)
+	file, _ := writer.Create("../evil")
+	file.Write([]byte("x"))
+	writer.Close()
+
+	parent, dest := tempDest(t)
+	defer os.RemoveAll(parent)
+
+	archive, err := OpenArchive(&buf, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archive.Extract(dest, ArchiveLimits{}); err == nil {
+		t.Error("expected an error")
+	}
+	assertContained(t, parent, dest)
+}
+
+func FuzzUntar(f *testing.F) {
+	f.Add(tarball(f,
+		entry{name: "s0", typeflag: tar.TypeSymlink, linkname: "."},
+		entry{name: "s1", typeflag: tar.TypeSymlink, linkname: "s0/.."},
+		entry{name: "s1/evil", typeflag: tar.TypeReg, body: "x"},
+	))
+	f.Add(tarball(f,
+		entry{name: "dir/", typeflag: tar.TypeDir},
+		entry{name: "dir/link", typeflag: tar.TypeSymlink, linkname: ".."},
+		entry{name: "dir/link/file", typeflag: tar.TypeReg, body: "x"},
+		entry{name: "hard", typeflag: tar.TypeLink, linkname: "dir/link/file"},
+	))
+	f.Add(tarball(f,
+		entry{name: "repo/", typeflag: tar.TypeDir},
+		entry{name: "repo/s0", typeflag: tar.TypeSymlink, linkname: ".."},
+		entry{name: "repo/s1", typeflag: tar.TypeSymlink, linkname: "s0/../env/.cf/config.json"},
+	))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parent, dest := tempDest(t)
+		defer os.RemoveAll(parent)
+
+		err := untar(bytes.NewReader(data), dest, ArchiveLimits{MaxSize: 1 << 20, MaxFiles: 100})
+		assertContained(t, parent, dest)
+		if err != nil {
+			return
+		}
+		e, _ := newExtractor(dest, ArchiveLimits{})
+		if err := e.checkSymlinks(); err != nil {
+			t.Fatalf("extracted tree has an escaping symlink: %s", err)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package helpers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// DownloadReleaseAsset opens the named asset of the release tagged tag.
+func DownloadReleaseAsset(client *github.Client, owner, repo, tag, name string) (io.ReadCloser, error) {
+	asset, err := findReleaseAsset(client, owner, repo, tag, name)
+	if err != nil {
+		return nil, err
+	}
+	if asset == nil {
+		return nil, fmt.Errorf("Release %s of %s/%s has no asset %s", tag, owner, repo, name)
+	}
+	return openReleaseAsset(client, owner, repo, asset)
+}
+
+// HasReleaseAsset reports whether the release tagged tag has the named asset.
+func HasReleaseAsset(client *github.Client, owner, repo, tag, name string) (bool, error) {
+	asset, err := findReleaseAsset(client, owner, repo, tag, name)
+	return asset != nil, err
+}
+
+// findReleaseAsset returns nil if the release exists but has no such asset.
+func findReleaseAsset(client *github.Client, owner, repo, tag, name string) (*github.ReleaseAsset, error) {
+	release, _, err := client.Repositories.GetReleaseByTag(context.Background(), owner, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range release.Assets {
+		if release.Assets[idx].GetName() == name {
+			return &release.Assets[idx], nil
+		}
+	}
+	return nil, nil
+}
+
+func openReleaseAsset(client *github.Client, owner, repo string, asset *github.ReleaseAsset) (io.ReadCloser, error) {
+	reader, redirectURL, err := client.Repositories.DownloadReleaseAsset(context.Background(), owner, repo, asset.GetID())
+	if err != nil {
+		return nil, err
+	}
+	if reader != nil {
+		return reader, nil
+	}
+	return DownloadArtifact(redirectURL)
+}
+
+func DownloadArtifact(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Downloading %s returned %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// SaveArtifact writes reader to dir/name, refusing files over maxSize. When
+// checksum is set ("sha256:<hex>" or just the hex digest), the file must match it.
+func SaveArtifact(reader io.Reader, dir, name, checksum string, maxSize int64) (string, error) {
+	path := filepath.Join(dir, filepath.Base(name))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0755)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if maxSize > 0 {
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), reader)
+	if err != nil {
+		return "", err
+	}
+	if maxSize > 0 && size > maxSize {
+		return "", fmt.Errorf("Artifact %s is larger than %d bytes", name, maxSize)
+	}
+
+	if checksum != "" {
+		if err := VerifyChecksum("Artifact "+name, hash.Sum(nil), checksum); err != nil {
+			return "", err
+		}
+	}
+	return path, file.Close()
+}
+
+// VerifyChecksum compares a SHA-256 digest with checksum, written as
+// "sha256:<hex>" or just the hex digest.
+func VerifyChecksum(name string, sum []byte, checksum string) error {
+	expected := strings.ToLower(strings.TrimPrefix(checksum, "sha256:"))
+	actual := hex.EncodeToString(sum)
+	if expected != actual {
+		return fmt.Errorf("%s has checksum sha256:%s, expected sha256:%s", name, actual, expected)
+	}
+	return nil
+}
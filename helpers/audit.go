@@ -0,0 +1,163 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AuditLogin           = "login"
+	AuditLogout          = "logout"
+	AuditDeployRequested = "deploy.requested"
+	AuditServiceCreated  = "service.created"
+	AuditAppPushed       = "app.pushed"
+	AuditDeployFailed    = "deploy.failed"
+	AuditCleanup         = "deploy.cleanup"
+)
+
+// AuditEvent is one entry of the audit trail.
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	Deployment string    `json:"deployment_id,omitempty"`
+	UserID     string    `json:"user_id,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	Foundation string    `json:"foundation,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Ref        string    `json:"ref,omitempty"`
+	SHA        string    `json:"sha,omitempty"`
+	Artifact   string    `json:"artifact,omitempty"`
+	OrgGUID    string    `json:"org_guid,omitempty"`
+	SpaceGUID  string    `json:"space_guid,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// With copies the event for a later step of the same deployment.
+func (e AuditEvent) With(eventType, detail string) AuditEvent {
+	e.Type = eventType
+	e.Detail = detail
+	return e
+}
+
+type AuditSink interface {
+	Write(data []byte) error
+}
+
+// Auditor writes events to the configured sink. A nil Auditor drops them.
+type Auditor struct {
+	sink AuditSink
+}
+
+// NewAuditor reads AUDIT_SINK: a file path or file:// URL for JSON lines,
+// syslog:// (optionally with a host and port) or an http(s) webhook URL.
+func NewAuditor(config Config) (*Auditor, error) {
+	if config.AuditSink == "" {
+		return nil, nil
+	}
+	parsed, err := url.Parse(config.AuditSink)
+	if err != nil {
+		return nil, err
+	}
+
+	var sink AuditSink
+	switch parsed.Scheme {
+	case "", "file":
+		sink, err = newFileSink(parsed.Path)
+	case "syslog", "syslog+tcp":
+		sink, err = newSyslogSink(parsed)
+	case "http", "https":
+		sink = &webhookSink{url: config.AuditSink, client: &http.Client{Timeout: 10 * time.Second}}
+	default:
+		err = fmt.Errorf("Unknown audit sink %s", config.AuditSink)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Auditor{sink: sink}, nil
+}
+
+// Record timestamps and writes event. Failures are logged rather than
+// returned, so that auditing never breaks a deployment halfway.
+func (a *Auditor) Record(event AuditEvent) {
+	if a == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Error("Error encoding audit event")
+		return
+	}
+	if err := a.sink.Write(data); err != nil {
+		log.WithError(err).WithField("event", string(data)).Error("Error writing audit event")
+	}
+}
+
+type fileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (s *fileSink) Write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.file.Write(append(data, '\n'))
+	return err
+}
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(parsed *url.URL) (*syslogSink, error) {
+	network := ""
+	if parsed.Host != "" {
+		network = "udp"
+		if parsed.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+	}
+	writer, err := syslog.Dial(network, parsed.Host, syslog.LOG_INFO|syslog.LOG_AUTH, "deploy-to-cf")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Write(data []byte) error {
+	return s.writer.Info(string(data))
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Write(data []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package helpers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"text/template"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+var colorPattern = regexp.MustCompile("^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$")
+
+// Badge is a two-part "label | message" deploy button.
+type Badge struct {
+	Label      string
+	Message    string
+	LabelColor string
+	Color      string
+}
+
+const (
+	badgeHeight    = 20
+	badgeCharWidth = 7
+	badgePadding   = 6
+	// The badge endpoint is public, and a PNG's memory grows with its width.
+	maxBadgeText = 64
+)
+
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{html .Label}}: {{html .Message}}">
+<title>{{html .Label}}: {{html .Message}}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{.Width}}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="{{.LabelWidth}}" height="20" fill="{{.LabelColor}}"/>
+<rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="20" fill="{{.Color}}"/>
+<rect width="{{.Width}}" height="20" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{.LabelX}}" y="14">{{html .Label}}</text>
+<text x="{{.MessageX}}" y="14">{{html .Message}}</text>
+</g>
+</svg>
+`))
+
+// Validate rejects colors that aren't hex codes, which also keeps them from
+// breaking out of the SVG attributes they're written into, and overlong text.
+func (b Badge) Validate() error {
+	for _, text := range []string{b.Label, b.Message} {
+		if len([]rune(text)) > maxBadgeText {
+			return fmt.Errorf("Badge text can be at most %d characters", maxBadgeText)
+		}
+	}
+	for _, c := range []string{b.LabelColor, b.Color} {
+		if !colorPattern.MatchString(c) {
+			return fmt.Errorf("Invalid color %q", c)
+		}
+	}
+	return nil
+}
+
+func (b Badge) widths() (int, int) {
+	return len([]rune(b.Label))*badgeCharWidth + 2*badgePadding, len([]rune(b.Message))*badgeCharWidth + 2*badgePadding
+}
+
+func (b Badge) SVG(w io.Writer) error {
+	labelWidth, messageWidth := b.widths()
+	return badgeTemplate.Execute(w, map[string]interface{}{
+		"Label":        b.Label,
+		"Message":      b.Message,
+		"LabelColor":   "#" + normalizeColor(b.LabelColor),
+		"Color":        "#" + normalizeColor(b.Color),
+		"LabelWidth":   labelWidth,
+		"MessageWidth": messageWidth,
+		"Width":        labelWidth + messageWidth,
+		"LabelX":       labelWidth / 2,
+		"MessageX":     labelWidth + messageWidth/2,
+	})
+}
+
+func (b Badge) PNG(w io.Writer) error {
+	labelWidth, messageWidth := b.widths()
+	img := image.NewRGBA(image.Rect(0, 0, labelWidth+messageWidth, badgeHeight))
+	draw.Draw(img, image.Rect(0, 0, labelWidth, badgeHeight), image.NewUniform(parseColor(b.LabelColor)), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(labelWidth, 0, labelWidth+messageWidth, badgeHeight), image.NewUniform(parseColor(b.Color)), image.ZP, draw.Src)
+
+	drawer := &font.Drawer{Dst: img, Src: image.White, Face: basicfont.Face7x13}
+	drawer.Dot = fixed.P(badgePadding, 14)
+	drawer.DrawString(b.Label)
+	drawer.Dot = fixed.P(labelWidth+badgePadding, 14)
+	drawer.DrawString(b.Message)
+
+	return png.Encode(w, img)
+}
+
+func normalizeColor(c string) string {
+	hex := colorPattern.FindStringSubmatch(c)[1]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	return hex
+}
+
+func parseColor(c string) color.Color {
+	value, _ := strconv.ParseUint(normalizeColor(c), 16, 32)
+	return color.RGBA{uint8(value >> 16), uint8(value >> 8), uint8(value), 0xff}
+}
@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBadgeValidate(t *testing.T) {
+	badge := Badge{Label: "Deploy to", Message: "Cloud Foundry", LabelColor: "555", Color: "#0a6cb5"}
+	if err := badge.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	long := badge
+	long.Message = strings.Repeat("x", maxBadgeText+1)
+	if err := long.Validate(); err == nil {
+		t.Error("expected overlong text to be rejected")
+	}
+
+	injected := badge
+	injected.Color = `fff" onload="alert(1)`
+	if err := injected.Validate(); err == nil {
+		t.Error("expected an invalid color to be rejected")
+	}
+}
@@ -0,0 +1,256 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	StrategyRecreate  = "recreate"
+	StrategyBlueGreen = "blue-green"
+)
+
+func ValidStrategy(strategy string) bool {
+	return strategy == StrategyRecreate || strategy == StrategyBlueGreen
+}
+
+type ccRoute struct {
+	GUID         string `json:"guid"`
+	URL          string `json:"url"`
+	Destinations []struct {
+		GUID string `json:"guid"`
+		App  struct {
+			GUID string `json:"guid"`
+		} `json:"app"`
+	} `json:"destinations"`
+}
+
+// blueGreen replaces a running app without downtime: the new version is
+// pushed under a temporary name with only the routes its manifest lists,
+// bound to the old app's services as well as the manifest's, and only once
+// it has started are the old app's routes moved to it. If anything fails
+// before the routes move, the new app is deleted and the old one keeps
+// serving.
+func (cf *CloudFoundry) blueGreen(manifest Manifest, name, manifestPath, path string) error {
+	oldGUID, err := cf.appGUID(name)
+	if err != nil {
+		return err
+	}
+	if oldGUID == "" {
+		return cf.createApp(name, manifestPath, path)
+	}
+
+	temp := name + "-deploying"
+	tempManifest := manifest.ForApp(name, temp)
+	tempPath := filepath.Join(cf.path, "manifest-"+temp+".yml")
+	if err := tempManifest.Save(tempPath); err != nil {
+		return err
+	}
+
+	// Without routes in the manifest, the push would make one for temp.
+	args := []string{"push", temp, "-f", tempPath, "-p", path, "--no-start"}
+	apps, err := tempManifest.Applications()
+	if err != nil {
+		return err
+	}
+	if len(apps) != 1 || len(apps[0].Routes) == 0 {
+		args = append(args, "--no-route")
+	}
+	if _, err := cf.run(args...); err != nil {
+		cf.rollback(temp)
+		return fmt.Errorf("The new version of %s failed to start, so the running version was left in place: %s", name, err)
+	}
+
+	newGUID, err := cf.appGUID(temp)
+	if err != nil {
+		cf.rollback(temp)
+		return err
+	}
+	if err := cf.copyBindings(oldGUID, newGUID, temp); err != nil {
+		cf.rollback(temp)
+		return err
+	}
+	if _, err := cf.run("start", temp); err != nil {
+		cf.rollback(temp)
+		return fmt.Errorf("The new version of %s failed to start, so the running version was left in place: %s", name, err)
+	}
+	routes, err := cf.appRoutes(oldGUID)
+	if err != nil {
+		cf.rollback(temp)
+		return err
+	}
+	for _, route := range routes {
+		body := map[string]interface{}{
+			"destinations": []interface{}{
+				map[string]interface{}{"app": map[string]string{"guid": newGUID}},
+			},
+		}
+		if err := cf.curl("POST", "/v3/routes/"+route.GUID+"/destinations", body, nil); err != nil {
+			cf.rollback(temp)
+			return err
+		}
+	}
+
+	// From here on the new version is serving, so there's nothing to roll back.
+	for _, route := range routes {
+		for _, destination := range route.Destinations {
+			if destination.App.GUID != oldGUID {
+				continue
+			}
+			if err := cf.curl("DELETE", "/v3/routes/"+route.GUID+"/destinations/"+destination.GUID, nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := cf.run("delete", name, "-f"); err != nil {
+		return err
+	}
+	_, err = cf.run("rename", temp, name)
+	return err
+}
+
+// copyBindings binds the app called temp to the services bound to the app
+// with oldGUID that it isn't bound to already, such as ones bound by hand
+// rather than listed in the manifest.
+func (cf *CloudFoundry) copyBindings(oldGUID, newGUID, temp string) error {
+	services, err := cf.boundServices(oldGUID)
+	if err != nil {
+		return err
+	}
+	bound, err := cf.boundServices(newGUID)
+	if err != nil {
+		return err
+	}
+	skip := map[string]bool{}
+	for _, service := range bound {
+		skip[service] = true
+	}
+	for _, service := range services {
+		if skip[service] {
+			continue
+		}
+		if _, err := cf.run("bind-service", temp, service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boundServices lists the names of the service instances bound to the app.
+func (cf *CloudFoundry) boundServices(guid string) ([]string, error) {
+	query := url.Values{}
+	query.Set("app_guids", guid)
+	query.Set("type", "app")
+	query.Set("include", "service_instance")
+	query.Set("per_page", "5000")
+	result := struct {
+		Included struct {
+			ServiceInstances []struct {
+				Name string `json:"name"`
+			} `json:"service_instances"`
+		} `json:"included"`
+	}{}
+	if err := cf.curl("GET", "/v3/service_credential_bindings?"+query.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, instance := range result.Included.ServiceInstances {
+		names = append(names, instance.Name)
+	}
+	return names, nil
+}
+
+// rollback deletes the temporary app; its routes, if any, are kept.
+func (cf *CloudFoundry) rollback(temp string) {
+	if _, err := cf.run("delete", temp, "-f"); err != nil {
+		cf.Logger.WithError(err).Warn("Error deleting " + temp)
+	}
+}
+
+// appGUID returns the GUID of the app called name in the target space, or
+// "" if there isn't one.
+func (cf *CloudFoundry) appGUID(name string) (string, error) {
+	query := url.Values{}
+	query.Set("names", name)
+	query.Set("space_guids", cf.data.SpaceFields.GUID)
+	result := struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+		} `json:"resources"`
+	}{}
+	if err := cf.curl("GET", "/v3/apps?"+query.Encode(), nil, &result); err != nil {
+		return "", err
+	}
+	if len(result.Resources) == 0 {
+		return "", nil
+	}
+	return result.Resources[0].GUID, nil
+}
+
+func (cf *CloudFoundry) appRoutes(guid string) ([]ccRoute, error) {
+	routes := []ccRoute{}
+	next := "/v3/apps/" + guid + "/routes?per_page=5000"
+	for next != "" {
+		page := struct {
+			Pagination struct {
+				Next *struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			} `json:"pagination"`
+			Resources []ccRoute `json:"resources"`
+		}{}
+		if err := cf.curl("GET", next, nil, &page); err != nil {
+			return nil, err
+		}
+		routes = append(routes, page.Resources...)
+		next = ""
+		if page.Pagination.Next != nil {
+			// cf curl takes a path, and the links are absolute.
+			parsed, err := url.Parse(page.Pagination.Next.Href)
+			if err != nil {
+				return nil, err
+			}
+			next = parsed.RequestURI()
+		}
+	}
+	return routes, nil
+}
+
+// curl calls the Cloud Controller through cf curl, which doesn't fail on
+// error responses, so errors in the body are returned as CCErrors.
+func (cf *CloudFoundry) curl(method, path string, body, result interface{}) error {
+	args := []string{"curl", path, "-X", method}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-d", string(data))
+	}
+	output, err := cf.run(args...)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(output) == "" {
+		return nil
+	}
+
+	failure := v3ErrorResponse{}
+	if err := json.Unmarshal([]byte(output), &failure); err != nil {
+		return fmt.Errorf("Invalid response from %s: %s", path, err)
+	}
+	if len(failure.Errors) > 0 {
+		return &CCError{
+			Code:        failure.Errors[0].Code,
+			ErrorCode:   failure.Errors[0].Title,
+			Description: failure.Errors[0].Detail,
+		}
+	}
+	if result != nil {
+		return json.Unmarshal([]byte(output), result)
+	}
+	return nil
+}
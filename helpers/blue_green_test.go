@@ -0,0 +1,43 @@
+package helpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAppRoutesFollowsPages(t *testing.T) {
+	var cc *httptest.Server
+	cc = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `{"pagination": {"next": null}, "resources": [{"guid": "route-2"}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"pagination": {"next": {"href": "%s/v3/apps/app/routes?page=2"}}, "resources": [{"guid": "route-1"}]}`, cc.URL)
+	}))
+	defer cc.Close()
+	defer useFakeCF(t)()
+
+	home, err := ioutil.TempDir("", "cf-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	cf := NewCloudFoundry(Foundation{CFURL: cc.URL}, oauth2.Token{TokenType: "bearer", AccessToken: "token"}, home, "org", "org", "space", "space")
+	if err := cf.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := cf.appRoutes("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0].GUID != "route-1" || routes[1].GUID != "route-2" {
+		t.Errorf("got routes %+v", routes)
+	}
+}
@@ -0,0 +1,279 @@
+package helpers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	minio "github.com/minio/minio-go"
+)
+
+var ErrCacheMiss = errors.New("Not in cache")
+
+// Cache stores immutable blobs, such as manifests and archives of a commit,
+// by key.
+type Cache interface {
+	Get(key string) (io.ReadCloser, error)
+	Put(key string, reader io.Reader) error
+}
+
+// NewCache builds the cache described by the configuration, or returns nil
+// when caching is disabled.
+func NewCache(config Config) (Cache, error) {
+	if config.CacheSize <= 0 {
+		return nil, nil
+	}
+	dir := config.CacheDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "deploy-to-cf-cache")
+	}
+	disk, err := NewDiskCache(dir, config.CacheSize)
+	if err != nil {
+		return nil, err
+	}
+	if config.CacheS3Bucket == "" {
+		return disk, nil
+	}
+
+	client, err := minio.New(config.CacheS3Endpoint, config.CacheS3AccessKey, config.CacheS3SecretKey, config.CacheS3Secure)
+	if err != nil {
+		return nil, err
+	}
+	return &TieredCache{
+		Local:  disk,
+		Remote: &S3Cache{client: client, bucket: config.CacheS3Bucket},
+	}, nil
+}
+
+// CacheKey builds a key for a file of a repository at a commit.
+func CacheKey(provider, owner, repo, sha, name string) string {
+	return strings.Join([]string{provider, owner, repo, sha, name}, "/")
+}
+
+// DiskCache keeps blobs in a directory and evicts the least recently used
+// ones once their total size passes maxSize.
+type DiskCache struct {
+	dir     string
+	maxSize int64
+	size    int64
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+
+	// Pick up what a previous process cached, oldest first.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") || !info.Mode().IsRegular() {
+			continue
+		}
+		c.entries[info.Name()] = c.lru.PushFront(&diskEntry{info.Name(), info.Size()})
+		c.size += info.Size()
+	}
+	c.evict()
+	return c, nil
+}
+
+func (c *DiskCache) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *DiskCache) Get(key string) (io.ReadCloser, error) {
+	name := c.filename(key)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[name]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	file, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		c.remove(elem)
+		return nil, ErrCacheMiss
+	}
+	c.lru.MoveToFront(elem)
+	now := time.Now()
+	os.Chtimes(file.Name(), now, now)
+	return file, nil
+}
+
+func (c *DiskCache) Put(key string, reader io.Reader) error {
+	name := c.filename(key)
+
+	temp, err := ioutil.TempFile(c.dir, ".put")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	size, err := io.Copy(temp, reader)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := os.Rename(temp.Name(), filepath.Join(c.dir, name)); err != nil {
+		return err
+	}
+	if elem, ok := c.entries[name]; ok {
+		c.size -= elem.Value.(*diskEntry).size
+		c.lru.Remove(elem)
+	}
+	c.entries[name] = c.lru.PushFront(&diskEntry{name, size})
+	c.size += size
+	c.evict()
+	return nil
+}
+
+func (c *DiskCache) evict() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *DiskCache) remove(elem *list.Element) {
+	entry := elem.Value.(*diskEntry)
+	os.Remove(filepath.Join(c.dir, entry.name))
+	c.lru.Remove(elem)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+}
+
+// S3Cache keeps blobs in a bucket of any S3-compatible object store, so
+// that every instance shares them.
+type S3Cache struct {
+	client *minio.Client
+	bucket string
+}
+
+func (c *S3Cache) Get(key string) (io.ReadCloser, error) {
+	object, err := c.client.GetObject(c.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (c *S3Cache) Put(key string, reader io.Reader) error {
+	_, err := c.client.PutObject(c.bucket, key, reader, -1, minio.PutObjectOptions{})
+	return err
+}
+
+// TieredCache reads through a local cache to a shared remote one.
+type TieredCache struct {
+	Local  Cache
+	Remote Cache
+}
+
+func (c *TieredCache) Get(key string) (io.ReadCloser, error) {
+	reader, err := c.Local.Get(key)
+	if err == nil {
+		return reader, nil
+	}
+
+	reader, err = c.Remote.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// The local copy may be evicted as soon as it's written, so read from a
+	// spooled one.
+	file, err := spool(reader)
+	if err != nil {
+		return nil, err
+	}
+	c.Local.Put(key, file)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func (c *TieredCache) Put(key string, reader io.Reader) error {
+	file, err := spool(reader)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := c.Local.Put(key, file); err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return c.Remote.Put(key, file)
+}
+
+// spooledFile is a temporary file that is removed when closed.
+type spooledFile struct {
+	*os.File
+}
+
+func (f spooledFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// spool copies reader to a temporary file, rewound for reading.
+func spool(reader io.Reader) (spooledFile, error) {
+	file, err := ioutil.TempFile("", "cache")
+	if err != nil {
+		return spooledFile{}, err
+	}
+	spooled := spooledFile{file}
+	if _, err := io.Copy(file, reader); err != nil {
+		spooled.Close()
+		return spooledFile{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return spooledFile{}, err
+	}
+	return spooled, nil
+}
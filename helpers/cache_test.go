@@ -0,0 +1,58 @@
+package helpers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type memoryCache map[string][]byte
+
+func (c memoryCache) Get(key string) (io.ReadCloser, error) {
+	data, ok := c[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c memoryCache) Put(key string, reader io.Reader) error {
+	data, err := ioutil.ReadAll(reader)
+	c[key] = data
+	return err
+}
+
+// A local cache too small to hold an entry evicts it as soon as it's
+// written; the tiered cache must still work.
+func TestTieredCacheWithEvictingLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := NewDiskCache(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := memoryCache{"fetched": []byte("from the remote")}
+	cache := &TieredCache{Local: local, Remote: remote}
+
+	if err := cache.Put("stored", bytes.NewReader([]byte("too big to keep locally"))); err != nil {
+		t.Fatal(err)
+	}
+	if string(remote["stored"]) != "too big to keep locally" {
+		t.Errorf("remote has %q", remote["stored"])
+	}
+
+	reader, err := cache.Get("fetched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	data, _ := ioutil.ReadAll(reader)
+	if string(data) != "from the remote" {
+		t.Errorf("got %q", data)
+	}
+}
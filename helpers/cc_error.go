@@ -0,0 +1,86 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CCError is an error response from the Cloud Controller.
+type CCError struct {
+	StatusCode  int
+	Code        int    `json:"code"`
+	ErrorCode   string `json:"error_code"`
+	Description string `json:"description"`
+}
+
+type v3ErrorResponse struct {
+	Errors []struct {
+		Code   int    `json:"code"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+// The cf CLI reports Cloud Controller errors in this format.
+var cliErrorPattern = regexp.MustCompile(`status code: (\d+), error code: (\d+), message: (.*)`)
+
+func (e *CCError) Error() string {
+	if e.ErrorCode != "" {
+		return fmt.Sprintf("Cloud Controller error %d %s: %s", e.StatusCode, e.ErrorCode, e.Description)
+	}
+	return fmt.Sprintf("Cloud Controller error %d: %s", e.StatusCode, e.Description)
+}
+
+func (e *CCError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.ErrorCode == "CF-InvalidAuthToken" || e.ErrorCode == "CF-NotAuthenticated"
+}
+
+// Message describes the error in terms a user can act on.
+func (e *CCError) Message() string {
+	switch {
+	case e.Unauthorized():
+		return "Your session has expired. Please sign in again."
+	case e.StatusCode == http.StatusForbidden:
+		return "You are not authorized to do this: " + e.Description
+	case e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusGatewayTimeout:
+		return "Cloud Foundry is currently unavailable, possibly for maintenance. Please try again later."
+	}
+	return e.Description
+}
+
+// NewCCError reads an error response body in either the v2 or the v3 format.
+func NewCCError(resp *http.Response) *CCError {
+	ccErr := &CCError{StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	v3 := v3ErrorResponse{}
+	if err := json.Unmarshal(body, &v3); err == nil && len(v3.Errors) > 0 {
+		ccErr.Code = v3.Errors[0].Code
+		ccErr.ErrorCode = v3.Errors[0].Title
+		ccErr.Description = v3.Errors[0].Detail
+	} else if err := json.Unmarshal(body, ccErr); err != nil || ccErr.Description == "" {
+		ccErr.Description = http.StatusText(resp.StatusCode)
+	}
+	return ccErr
+}
+
+// ParseCLIError turns the output of a failed cf command into a CCError when
+// the CLI reported one.
+func ParseCLIError(output string) error {
+	match := cliErrorPattern.FindStringSubmatch(output)
+	if match == nil {
+		return nil
+	}
+	status, _ := strconv.Atoi(match[1])
+	code, _ := strconv.Atoi(match[2])
+	return &CCError{
+		StatusCode:  status,
+		Code:        code,
+		Description: strings.TrimSpace(match[3]),
+	}
+}
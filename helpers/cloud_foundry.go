@@ -3,39 +3,47 @@ package helpers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"code.cloudfoundry.org/cli/cf/commandregistry"
-	"code.cloudfoundry.org/cli/cf/commandsloader"
 	"code.cloudfoundry.org/cli/cf/configuration/coreconfig"
-	"code.cloudfoundry.org/cli/cf/flags"
 	"code.cloudfoundry.org/cli/cf/models"
-	"code.cloudfoundry.org/cli/cf/requirements"
-	"code.cloudfoundry.org/cli/cf/trace"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 )
 
 type CloudFoundry struct {
 	path string
 	data coreconfig.Data
+
+	// Notify, if set, is told about each service created and the app pushed.
+	Notify func(eventType, detail string)
+	// Logger receives the output of cf commands.
+	Logger *log.Entry
+	// Source, if set, is recorded on the pushed apps.
+	Source *AppSource
+
+	phase string
 }
 
-func NewCloudFoundry(config Config, token oauth2.Token, path, orgGUID, orgName, spaceGUID, spaceName string) *CloudFoundry {
+func NewCloudFoundry(foundation Foundation, token oauth2.Token, path, orgGUID, orgName, spaceGUID, spaceName string) *CloudFoundry {
 	return &CloudFoundry{
-		path: path,
+		path:   path,
+		Logger: log.NewEntry(log.StandardLogger()),
 		data: coreconfig.Data{
-			Target:                config.CFURL,
-			AuthorizationEndpoint: config.AuthURL,
-			UaaEndpoint:           config.TokenURL,
-			UAAOAuthClient:        config.ClientID,
-			UAAOAuthClientSecret:  config.ClientSecret,
+			Target:                foundation.CFURL,
+			AuthorizationEndpoint: foundation.AuthURL,
+			UaaEndpoint:           foundation.TokenURL,
+			UAAOAuthClient:        foundation.ClientID,
+			UAAOAuthClientSecret:  foundation.ClientSecret,
 			AccessToken:           fmt.Sprintf("%s %s", token.TokenType, token.AccessToken),
 			RefreshToken:          token.RefreshToken,
 			OrganizationFields: models.OrganizationFields{
@@ -66,18 +74,59 @@ func (cf *CloudFoundry) WriteConfig() error {
 	return ioutil.WriteFile(path, output, 0644)
 }
 
-func (cf *CloudFoundry) Create(app App, manifest, path string, timeout int) (string, error) {
-	err := cf.createServices(app, timeout)
+func (cf *CloudFoundry) Create(app App, manifestPath, path string, timeout int) (string, error) {
+	manifest, err := NewManifest(manifestPath)
+	if err != nil {
+		return "", err
+	}
+	apps, err := manifest.Applications()
 	if err != nil {
 		return "", err
 	}
+	for _, settings := range apps {
+		if settings.Name == "" {
+			return "", errors.New("Every application in manifest.yml must have a name")
+		}
+	}
 
-	err = cf.createApp("testapp", manifest, path)
+	cf.phase = "services"
+	err = cf.createServices(app, timeout)
 	if err != nil {
 		return "", err
 	}
 
-	return cf.getRoute("testapp")
+	routes := []string{}
+	for _, settings := range apps {
+		cf.phase = "push"
+		if app.Strategy == StrategyBlueGreen {
+			err = cf.blueGreen(manifest, settings.Name, manifestPath, path)
+		} else {
+			err = cf.createApp(settings.Name, manifestPath, path)
+		}
+		if err != nil {
+			return "", err
+		}
+		cf.notify(AuditAppPushed, settings.Name)
+		if cf.Source != nil {
+			// Older Cloud Controllers don't support metadata; the app works without it.
+			if err := cf.label(settings.Name); err != nil {
+				cf.Logger.WithError(err).Warn("Error labelling " + settings.Name)
+			}
+		}
+
+		cf.phase = "route"
+		route, err := cf.getRoute(settings.Name)
+		if err != nil {
+			return "", err
+		}
+		routes = append(routes, route)
+	}
+	return strings.Join(routes, ", "), nil
+}
+
+// Phase is the step of Create that is running, or that failed.
+func (cf *CloudFoundry) Phase() string {
+	return cf.phase
 }
 
 func (cf *CloudFoundry) createServices(app App, timeout int) error {
@@ -90,7 +139,19 @@ func (cf *CloudFoundry) createServices(app App, timeout int) error {
 	return nil
 }
 
+// createService creates the service unless the space already has one with
+// its name, as it does when an app is updated, so that existing bindings
+// and data are kept.
 func (cf *CloudFoundry) createService(service Service, timeout int) error {
+	exists, err := cf.serviceExists(service.Label)
+	if err != nil {
+		return err
+	}
+	if exists {
+		cf.Logger.WithField("service", service.Label).Info("Service already exists")
+		return nil
+	}
+
 	args := []string{"create-service", service.Service, service.Plan, service.Label}
 	if len(service.Tags) > 0 {
 		args = append(args, "-t", strings.Join(service.Tags, ","))
@@ -103,12 +164,40 @@ func (cf *CloudFoundry) createService(service Service, timeout int) error {
 		args = append(args, "-c", string(config))
 	}
 
-	err := cf.cf(args...).Run()
+	_, err = cf.run(args...)
 	if err != nil {
 		return err
 	}
 
-	return cf.checkService(service, timeout)
+	start := time.Now()
+	err = cf.checkService(service, timeout)
+	ServiceProvisioningDuration.WithLabelValues(service.Service, resultLabel(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+	cf.notify(AuditServiceCreated, fmt.Sprintf("%s (%s %s)", service.Label, service.Service, service.Plan))
+	return nil
+}
+
+func (cf *CloudFoundry) serviceExists(name string) (bool, error) {
+	query := url.Values{}
+	query.Set("names", name)
+	query.Set("space_guids", cf.data.SpaceFields.GUID)
+	result := struct {
+		Resources []struct {
+			GUID string `json:"guid"`
+		} `json:"resources"`
+	}{}
+	if err := cf.curl("GET", "/v3/service_instances?"+query.Encode(), nil, &result); err != nil {
+		return false, err
+	}
+	return len(result.Resources) > 0, nil
+}
+
+func (cf *CloudFoundry) notify(eventType, detail string) {
+	if cf.Notify != nil {
+		cf.Notify(eventType, detail)
+	}
 }
 
 func (cf *CloudFoundry) checkService(service Service, timeout int) error {
@@ -116,17 +205,15 @@ func (cf *CloudFoundry) checkService(service Service, timeout int) error {
 	elapsed := 0
 
 	for {
-		buf := bytes.Buffer{}
-		cmd := cf.cf(args...)
-		cmd.Stdout = io.MultiWriter(os.Stdout, &buf)
-		err := cmd.Run()
-
+		output, err := cf.run(args...)
 		if err == nil {
-			output := buf.String()
 			for _, line := range strings.Split(output, "\n") {
 				if line == "Status: create succeeded" {
 					return nil
 				}
+				if line == "Status: create failed" {
+					return fmt.Errorf("Service %s failed to provision", service.Label)
+				}
 			}
 		}
 
@@ -140,60 +227,50 @@ func (cf *CloudFoundry) checkService(service Service, timeout int) error {
 }
 
 func (cf *CloudFoundry) createApp(app, manifest, path string) error {
-	os.Setenv("CF_HOME", cf.path)
-	defer os.Unsetenv("CF_HOME")
-
-	traceLogger := trace.NewLogger(os.Stdout, false, "", "")
-
-	deps := commandregistry.NewDependency(os.Stdout, traceLogger, os.Getenv("CF_DIAL_TIMEOUT"))
-	defer deps.Config.Close()
+	_, err := cf.run("push", app, "-f", manifest, "-p", path)
+	return err
+}
 
-	commandsloader.Load()
-	cmd := commandregistry.Commands.FindCommand("push")
-	cmd = cmd.SetDependency(deps, false)
+func (cf *CloudFoundry) cf(args ...string) *exec.Cmd {
+	cmd := exec.Command("cf", args...)
+	cmd.Env = append(os.Environ(), "CF_COLOR=false", fmt.Sprintf("CF_HOME=%s", cf.path))
+	return cmd
+}
 
-	meta := cmd.MetaData()
-	flagContext := flags.NewFlagContext(meta.Flags)
-	flagContext.SkipFlagParsing(meta.SkipFlagParsing)
-	flagContext.Parse(app, "-f", manifest, "-p", path)
+// run executes a cf command, returning its output and, when it fails, the
+// Cloud Controller error the CLI reported. The output is also logged.
+func (cf *CloudFoundry) run(args ...string) (string, error) {
+	output := cf.Logger.WithField("command", args[0]).Writer()
+	defer output.Close()
 
-	requirementsFactory := requirements.NewFactory(deps.Config, deps.RepoLocator)
-	reqs, err := cmd.Requirements(requirementsFactory, flagContext)
+	buf := bytes.Buffer{}
+	cmd := cf.cf(args...)
+	cmd.Stdout = io.MultiWriter(output, &buf)
+	cmd.Stderr = io.MultiWriter(output, &buf)
+	start := time.Now()
+	err := cmd.Run()
+	CLICommands.WithLabelValues(args[0], resultLabel(err)).Observe(time.Since(start).Seconds())
 	if err != nil {
-		return err
-	}
-
-	for _, req := range reqs {
-		err = req.Execute()
-		if err != nil {
-			return err
+		if ccErr := ParseCLIError(buf.String()); ccErr != nil {
+			return buf.String(), ccErr
 		}
+		return buf.String(), fmt.Errorf("cf %s failed: %s", args[0], lastLine(buf.String()))
 	}
-
-	return cmd.Execute(flagContext)
+	return buf.String(), nil
 }
 
-func (cf *CloudFoundry) cf(args ...string) *exec.Cmd {
-	cmd := exec.Command("cf", args...)
-
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(), "CF_COLOR=true", fmt.Sprintf("CF_HOME=%s", cf.path))
-
-	return cmd
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
 }
 
 func (cf *CloudFoundry) getRoute(name string) (string, error) {
-	buf := bytes.Buffer{}
-	cmd := cf.cf("app", name)
-	cmd.Stdout = io.MultiWriter(os.Stdout, &buf)
-	err := cmd.Run()
+	output, err := cf.run("app", name)
 	if err != nil {
 		return "", err
 	}
 
-	output := strings.Split(buf.String(), "\n")
-	for _, line := range output {
+	for _, line := range strings.Split(output, "\n") {
 		if strings.Index(line, "urls: ") == 0 {
 			return strings.Replace(line, "urls: ", "", 1), nil
 		}
@@ -2,6 +2,7 @@ package helpers
 
 import (
 	"encoding/base64"
+	"errors"
 	"html/template"
 	"image"
 	"image/png"
@@ -10,28 +11,103 @@ import (
 	"strings"
 
 	"github.com/gorilla/sessions"
-	"golang.org/x/oauth2"
+	log "github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	SecretKey      string `envconfig:"SECRET_KEY" required:"true"`
-	SecureCookies  bool   `envconfig:"SECURE_COOKIES" default:"true"`
-	Hostname       string `envconfig:"HOSTNAME" required:"true"`
-	ClientID       string `envconfig:"CLIENT_ID" required:"true"`
-	ClientSecret   string `envconfig:"CLIENT_SECRET" required:"true"`
-	AuthURL        string `envconfig:"AUTH_URL" required:"true"`
-	TokenURL       string `envconfig:"TOKEN_URL" required:"true"`
-	CFURL          string `envconfig:"CF_URL" required:"true"`
-	ServiceTimeout int    `envconfig:"SERVICE_TIMEOUT" default:"600"`
-	Port           string `envconfig:"PORT" default:"3000"`
-	ButtonLogo     string `envconfig:"BUTTON_LOGO"`
+	SecretKey            string   `envconfig:"SECRET_KEY" required:"true"`
+	SecureCookies        bool     `envconfig:"SECURE_COOKIES" default:"true"`
+	Hostname             string   `envconfig:"HOSTNAME" required:"true"`
+	ClientID             string   `envconfig:"CLIENT_ID"`
+	ClientSecret         string   `envconfig:"CLIENT_SECRET"`
+	AuthURL              string   `envconfig:"AUTH_URL"`
+	TokenURL             string   `envconfig:"TOKEN_URL"`
+	CFURL                string   `envconfig:"CF_URL"`
+	FoundationsFile      string   `envconfig:"FOUNDATIONS_FILE"`
+	ServiceTimeout       int      `envconfig:"SERVICE_TIMEOUT" default:"600"`
+	ResultsPerPage       int      `envconfig:"RESULTS_PER_PAGE" default:"100"`
+	MaxArchiveSize       int64    `envconfig:"MAX_ARCHIVE_SIZE" default:"536870912"`
+	MaxArchiveFiles      int      `envconfig:"MAX_ARCHIVE_FILES" default:"50000"`
+	AllowedSources       []string `envconfig:"ALLOWED_SOURCES"`
+	DeniedSources        []string `envconfig:"DENIED_SOURCES"`
+	AllowedRefs          []string `envconfig:"ALLOWED_REFS"`
+	RequireTags          bool     `envconfig:"REQUIRE_TAGS"`
+	AllowedArtifactHosts []string `envconfig:"ALLOWED_ARTIFACT_HOSTS"`
+	PolicyFile           string   `envconfig:"POLICY_FILE"`
+	VerifySources        string   `envconfig:"VERIFY_SOURCES"`
+	TrustedKeysFile      string   `envconfig:"TRUSTED_KEYS_FILE"`
+	AllowedSignersFile   string   `envconfig:"ALLOWED_SIGNERS_FILE"`
+	ChecksumsAsset       string   `envconfig:"CHECKSUMS_ASSET" default:"SHA256SUMS"`
+	AuditSink            string   `envconfig:"AUDIT_SINK"`
+	CacheDir             string   `envconfig:"CACHE_DIR"`
+	CacheSize            int64    `envconfig:"CACHE_SIZE" default:"268435456"`
+	CacheS3Endpoint      string   `envconfig:"CACHE_S3_ENDPOINT" default:"s3.amazonaws.com"`
+	CacheS3Bucket        string   `envconfig:"CACHE_S3_BUCKET"`
+	CacheS3AccessKey     string   `envconfig:"CACHE_S3_ACCESS_KEY"`
+	CacheS3SecretKey     string   `envconfig:"CACHE_S3_SECRET_KEY"`
+	CacheS3Secure        bool     `envconfig:"CACHE_S3_SECURE" default:"true"`
+	TargetCacheTTL       int      `envconfig:"TARGET_CACHE_TTL" default:"60"`
+	SessionTimeout       int      `envconfig:"SESSION_TIMEOUT" default:"3600"`
+	SessionStore         string   `envconfig:"SESSION_STORE" default:"filesystem"`
+	SessionStoreURL      string   `envconfig:"SESSION_STORE_URL"`
+	SessionEncryptionKey string   `envconfig:"SESSION_ENCRYPTION_KEY"`
+	UAALogout            bool     `envconfig:"UAA_LOGOUT" default:"true"`
+	LogLevel             string   `envconfig:"LOG_LEVEL" default:"info"`
+	MetricsUsername      string   `envconfig:"METRICS_USERNAME"`
+	MetricsPassword      string   `envconfig:"METRICS_PASSWORD"`
+	MetricsPort          string   `envconfig:"METRICS_PORT"`
+	DeploymentStore      string   `envconfig:"DEPLOYMENT_STORE"`
+	DeploymentStoreURL   string   `envconfig:"DEPLOYMENT_STORE_URL"`
+	DrainTimeout         int      `envconfig:"DRAIN_TIMEOUT" default:"10"`
+	DeployConcurrency    int      `envconfig:"DEPLOY_CONCURRENCY" default:"4"`
+	DeployQueue          int      `envconfig:"DEPLOY_QUEUE" default:"20"`
+	DeploymentLease      int      `envconfig:"DEPLOYMENT_LEASE" default:"60"`
+	DeploymentRetention  int      `envconfig:"DEPLOYMENT_RETENTION" default:"30"`
+	Instance             string   `envconfig:"CF_INSTANCE_GUID"`
+	Port                 string   `envconfig:"PORT" default:"3000"`
+	ButtonLogo           string   `envconfig:"BUTTON_LOGO"`
 }
 
 type Context struct {
 	Store       sessions.Store
-	OauthConfig *oauth2.Config
+	Foundations []Foundation
+	Targets     *TargetCache
+	Refs        *RefCache
+	Cache       Cache
+	Policy      *Policy
+	Verifier    *Verifier
+	Audit       *Auditor
+	Deployments DeploymentStore
+	Queue       *DeployQueue
 	Templates   *template.Template
 	Config      Config
+	Logger      *log.Logger
+}
+
+func (c Config) ArchiveLimits() ArchiveLimits {
+	return ArchiveLimits{
+		MaxSize:  c.MaxArchiveSize,
+		MaxFiles: c.MaxArchiveFiles,
+	}
+}
+
+// Validate catches settings that envconfig accepts but that can't work.
+func (c Config) Validate() error {
+	if c.DeployConcurrency < 1 {
+		return errors.New("DEPLOY_CONCURRENCY must be at least 1")
+	}
+	if c.DeployQueue < 0 {
+		return errors.New("DEPLOY_QUEUE can't be negative")
+	}
+	if c.DeploymentLease < 1 || c.DeploymentRetention < 1 {
+		return errors.New("DEPLOYMENT_LEASE and DEPLOYMENT_RETENTION must be at least 1")
+	}
+	switch len(c.SessionEncryptionKey) {
+	case 0, 16, 24, 32:
+	default:
+		return errors.New("SESSION_ENCRYPTION_KEY must be 16, 24 or 32 bytes long")
+	}
+	return nil
 }
 
 type ContextHandler func(*Context, http.ResponseWriter, *http.Request)
@@ -0,0 +1,23 @@
+package helpers
+
+import "testing"
+
+func TestValidateSessionSettings(t *testing.T) {
+	valid := Config{DeployConcurrency: 1, DeploymentLease: 1, DeploymentRetention: 1, SessionStore: "redis"}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"0123456789abcdef", "0123456789abcdef01234567", "0123456789abcdef0123456789abcdef"} {
+		config := valid
+		config.SessionEncryptionKey = key
+		if err := config.Validate(); err != nil {
+			t.Errorf("%d byte key: %s", len(key), err)
+		}
+	}
+
+	short := valid
+	short.SessionEncryptionKey = "too short"
+	if err := short.Validate(); err == nil {
+		t.Error("expected an error for a short key")
+	}
+}
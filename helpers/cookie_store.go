@@ -0,0 +1,117 @@
+package helpers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// cookieChunkSize leaves room in each cookie for its name and
+	// attributes within the 4096 bytes browsers allow one.
+	cookieChunkSize = 3800
+	// maxCookieChunks bounds the cookies a session may take up.
+	maxCookieChunks = 8
+)
+
+// CookieStore keeps the whole session in the browser, signed and, with an
+// encryption key, encrypted. Tokens for a few foundations and the deploy
+// history are more than one cookie can hold, so the session is split across
+// cookies named name, name.1, name.2 and so on.
+type CookieStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+func NewCookieStore(keyPairs ...[]byte) *CookieStore {
+	store := &CookieStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+	store.MaxAge(store.Options.MaxAge)
+	return store
+}
+
+func (s *CookieStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+			sc.MaxLength(0)
+		}
+	}
+}
+
+func (s *CookieStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *CookieStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	encoded := ""
+	for idx := 0; idx < maxCookieChunks; idx++ {
+		cookie, err := r.Cookie(cookieChunkName(name, idx))
+		if err != nil {
+			break
+		}
+		encoded += cookie.Value
+	}
+	if encoded == "" {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, encoded, &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+func (s *CookieStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	chunks := []string{}
+	if session.Options.MaxAge >= 0 {
+		encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+		if err != nil {
+			return err
+		}
+		for len(encoded) > cookieChunkSize {
+			chunks = append(chunks, encoded[:cookieChunkSize])
+			encoded = encoded[cookieChunkSize:]
+		}
+		chunks = append(chunks, encoded)
+		if len(chunks) > maxCookieChunks {
+			return fmt.Errorf("Session needs %d cookies, more than the %d allowed", len(chunks), maxCookieChunks)
+		}
+	}
+
+	for idx, chunk := range chunks {
+		http.SetCookie(w, sessions.NewCookie(cookieChunkName(session.Name(), idx), chunk, session.Options))
+	}
+	// Clear the chunks left over from a larger session, or all of them.
+	expired := *session.Options
+	expired.MaxAge = -1
+	for idx := len(chunks); idx < maxCookieChunks; idx++ {
+		name := cookieChunkName(session.Name(), idx)
+		if _, err := r.Cookie(name); err == nil || idx == 0 {
+			http.SetCookie(w, sessions.NewCookie(name, "", &expired))
+		}
+	}
+	return nil
+}
+
+func cookieChunkName(name string, idx int) string {
+	if idx == 0 {
+		return name
+	}
+	return name + "." + strconv.Itoa(idx)
+}
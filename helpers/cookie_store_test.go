@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// roundTrip saves values into a response, then reads them back from a
+// request carrying the cookies a browser would keep.
+func roundTrip(t *testing.T, store *CookieStore, jar map[string]*http.Cookie, values map[interface{}]interface{}) map[interface{}]interface{} {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range jar {
+		r.AddCookie(cookie)
+	}
+	session, _ := store.New(r, "session")
+	session.Values = values
+	w := httptest.NewRecorder()
+	if err := store.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cookie := range w.Result().Cookies() {
+		if len(cookie.String()) > 4096 {
+			t.Errorf("cookie %s is %d bytes", cookie.Name, len(cookie.String()))
+		}
+		if cookie.MaxAge < 0 {
+			delete(jar, cookie.Name)
+		} else {
+			jar[cookie.Name] = cookie
+		}
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	for _, cookie := range jar {
+		r.AddCookie(cookie)
+	}
+	session, err := store.New(r, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Values
+}
+
+func TestCookieStoreSplitsLargeSessions(t *testing.T) {
+	store := NewCookieStore([]byte("signing key"), []byte("0123456789abcdef0123456789abcdef"))
+	jar := map[string]*http.Cookie{}
+
+	large := strings.Repeat("token.", 1500)
+	values := roundTrip(t, store, jar, map[interface{}]interface{}{"token": large})
+	if values["token"] != large {
+		t.Error("expected the large session to be read back")
+	}
+	if len(jar) < 2 {
+		t.Errorf("expected the session to be split, got %d cookies", len(jar))
+	}
+
+	values = roundTrip(t, store, jar, map[interface{}]interface{}{"token": "small"})
+	if values["token"] != "small" {
+		t.Errorf("got %v", values["token"])
+	}
+	if len(jar) != 1 {
+		t.Errorf("expected the old chunks to be cleared, got %d cookies", len(jar))
+	}
+}
@@ -0,0 +1,260 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the version of deploy-to-cf recorded on the apps it pushes. It
+// is set at build time with -ldflags "-X <package>.Version=<version>"; the Go
+// buildpack does so from GO_LINKER_SYMBOL and GO_LINKER_VALUE.
+var Version = "dev"
+
+// Apps pushed from a repository carry these labels, so that they can be
+// found again, and annotations recording what was pushed. Labels can only
+// hold short, restricted values, so the annotations are authoritative.
+const (
+	metadataPrefix    = "deploy-to-cf/"
+	LabelProvider     = metadataPrefix + "provider"
+	LabelOwner        = metadataPrefix + "owner"
+	LabelRepo         = metadataPrefix + "repo"
+	AnnotationOwner   = metadataPrefix + "owner"
+	AnnotationRepo    = metadataPrefix + "repo"
+	AnnotationRef     = metadataPrefix + "ref"
+	AnnotationSHA     = metadataPrefix + "sha"
+	AnnotationVersion = metadataPrefix + "version"
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// AppSource is what an app was pushed from.
+type AppSource struct {
+	Owner string
+	Repo  string
+	Ref   string
+	SHA   string
+}
+
+// Metadata is the body of the request labelling an app with its source.
+func (s AppSource) Metadata() map[string]interface{} {
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				LabelProvider: "github",
+				LabelOwner:    labelValue(s.Owner),
+				LabelRepo:     labelValue(s.Repo),
+			},
+			"annotations": map[string]string{
+				AnnotationOwner:   s.Owner,
+				AnnotationRepo:    s.Repo,
+				AnnotationRef:     s.Ref,
+				AnnotationSHA:     s.SHA,
+				AnnotationVersion: Version,
+			},
+		},
+	}
+}
+
+// labelValue fits value into a label: at most 63 of the allowed characters,
+// starting and ending with an alphanumeric one.
+func labelValue(value string) string {
+	value = invalidLabelChars.ReplaceAllString(value, "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "._-")
+}
+
+// label records where the app called name was pushed from.
+func (cf *CloudFoundry) label(name string) error {
+	guid, err := cf.appGUID(name)
+	if err != nil {
+		return err
+	}
+	if guid == "" {
+		return fmt.Errorf("App %s not found", name)
+	}
+	return cf.curl("PATCH", "/v3/apps/"+guid, cf.Source.Metadata(), nil)
+}
+
+// DeployedApp is an app pushed by deploy-to-cf. Latest is the commit its
+// ref points at now, when known.
+type DeployedApp struct {
+	GUID      string
+	Name      string
+	State     string
+	SpaceGUID string
+	SpaceName string
+	OrgName   string
+	Updated   time.Time
+	AppSource
+	Version string
+	Latest  string
+}
+
+// Outdated reports whether the app's ref has moved on since it was pushed.
+func (a DeployedApp) Outdated() bool {
+	return a.Latest != "" && a.Latest != a.SHA
+}
+
+func (a DeployedApp) CommitURL() string {
+	return fmt.Sprintf("https://github.com/%s/%s/commit/%s", a.Owner, a.Repo, a.SHA)
+}
+
+// UpdateURL opens the deploy form for the app's ref, prefilled from the app.
+func (a DeployedApp) UpdateURL() string {
+	query := url.Values{}
+	query.Set("owner", a.Owner)
+	query.Set("repo", a.Repo)
+	query.Set("ref", a.Ref)
+	query.Set("app", a.GUID)
+	return "/?" + query.Encode()
+}
+
+type v3App struct {
+	GUID      string    `json:"guid"`
+	Name      string    `json:"name"`
+	State     string    `json:"state"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Metadata  struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Relationships struct {
+		Space struct {
+			Data struct {
+				GUID string `json:"guid"`
+			} `json:"data"`
+		} `json:"space"`
+	} `json:"relationships"`
+}
+
+type v3Included struct {
+	Spaces []struct {
+		GUID          string `json:"guid"`
+		Name          string `json:"name"`
+		Relationships struct {
+			Organization struct {
+				Data struct {
+					GUID string `json:"guid"`
+				} `json:"data"`
+			} `json:"organization"`
+		} `json:"relationships"`
+	} `json:"spaces"`
+	Organizations []struct {
+		GUID string `json:"guid"`
+		Name string `json:"name"`
+	} `json:"organizations"`
+}
+
+func (i v3Included) deployedApp(app v3App) DeployedApp {
+	deployed := DeployedApp{
+		GUID:      app.GUID,
+		Name:      app.Name,
+		State:     app.State,
+		SpaceGUID: app.Relationships.Space.Data.GUID,
+		Updated:   app.UpdatedAt,
+		AppSource: AppSource{
+			Owner: app.Metadata.Annotations[AnnotationOwner],
+			Repo:  app.Metadata.Annotations[AnnotationRepo],
+			Ref:   app.Metadata.Annotations[AnnotationRef],
+			SHA:   app.Metadata.Annotations[AnnotationSHA],
+		},
+		Version: app.Metadata.Annotations[AnnotationVersion],
+	}
+	for _, space := range i.Spaces {
+		if space.GUID != deployed.SpaceGUID {
+			continue
+		}
+		deployed.SpaceName = space.Name
+		for _, org := range i.Organizations {
+			if org.GUID == space.Relationships.Organization.Data.GUID {
+				deployed.OrgName = org.Name
+			}
+		}
+	}
+	return deployed
+}
+
+// FetchDeployedApps lists the apps deploy-to-cf pushed that the user can see.
+func FetchDeployedApps(client *http.Client, foundation Foundation, resultsPerPage int) ([]DeployedApp, error) {
+	query := url.Values{}
+	query.Set("label_selector", LabelProvider)
+	query.Set("include", "space.organization")
+	query.Set("order_by", "name")
+	query.Set("per_page", strconv.Itoa(resultsPerPage))
+
+	apps := []DeployedApp{}
+	next := foundation.CFURL + "/v3/apps?" + query.Encode()
+	for next != "" {
+		page := struct {
+			Pagination struct {
+				Next *struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			} `json:"pagination"`
+			Resources []v3App    `json:"resources"`
+			Included  v3Included `json:"included"`
+		}{}
+		if err := getV3(client, next, &page); err != nil {
+			return []DeployedApp{}, err
+		}
+		for _, app := range page.Resources {
+			apps = append(apps, page.Included.deployedApp(app))
+		}
+		next = ""
+		if page.Pagination.Next != nil {
+			next = page.Pagination.Next.Href
+		}
+	}
+	return apps, nil
+}
+
+func FetchDeployedApp(client *http.Client, foundation Foundation, guid string) (DeployedApp, error) {
+	app := struct {
+		v3App
+		Included v3Included `json:"included"`
+	}{}
+	if err := getV3(client, foundation.CFURL+"/v3/apps/"+url.PathEscape(guid)+"?include=space.organization", &app); err != nil {
+		return DeployedApp{}, err
+	}
+	return app.Included.deployedApp(app.v3App), nil
+}
+
+// FetchAppEnv reads the environment variables set on an app.
+func FetchAppEnv(client *http.Client, foundation Foundation, guid string) (map[string]string, error) {
+	env := struct {
+		Var map[string]interface{} `json:"var"`
+	}{}
+	if err := getV3(client, foundation.CFURL+"/v3/apps/"+url.PathEscape(guid)+"/environment_variables", &env); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for name, value := range env.Var {
+		if value != nil {
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+func getV3(client *http.Client, url string, value interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return NewCCError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		return fmt.Errorf("Invalid response from %s: %s", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package helpers
+
+import "testing"
+
+func TestMetadataRecordsVersion(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "1.2.3"
+
+	source := AppSource{Owner: "o", Repo: "r", Ref: "main", SHA: "abc"}
+	metadata := source.Metadata()["metadata"].(map[string]interface{})
+	annotations := metadata["annotations"].(map[string]string)
+	if annotations[AnnotationVersion] != "1.2.3" || annotations[AnnotationSHA] != "abc" {
+		t.Errorf("got annotations %v", annotations)
+	}
+}
@@ -0,0 +1,459 @@
+package helpers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	DeploymentQueued    = "queued"
+	DeploymentRunning   = "running"
+	DeploymentSucceeded = "succeeded"
+	DeploymentFailed    = "failed"
+)
+
+var ErrDeploymentNotFound = errors.New("Deployment not found")
+
+// Deployment is the persisted state of one deployment. Instance is the GUID
+// of the app instance running it; once that instance stops sending
+// heartbeats, any other instance can tell the deployment was abandoned.
+type Deployment struct {
+	ID         string    `json:"id"`
+	Instance   string    `json:"instance"`
+	Status     string    `json:"status"`
+	Phase      string    `json:"phase"`
+	UserID     string    `json:"user_id"`
+	Foundation string    `json:"foundation"`
+	Owner      string    `json:"owner"`
+	Repo       string    `json:"repo"`
+	Ref        string    `json:"ref"`
+	SHA        string    `json:"sha"`
+	OrgGUID    string    `json:"org_guid"`
+	OrgName    string    `json:"org_name"`
+	SpaceGUID  string    `json:"space_guid"`
+	SpaceName  string    `json:"space_name"`
+	Strategy   string    `json:"strategy,omitempty"`
+	Services   []string  `json:"services,omitempty"`
+	Route      string    `json:"route,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	Updated    time.Time `json:"updated"`
+}
+
+func (d Deployment) Finished() bool {
+	return d.Status == DeploymentSucceeded || d.Status == DeploymentFailed
+}
+
+func (d Deployment) CommitURL() string {
+	return fmt.Sprintf("https://github.com/%s/%s/commit/%s", d.Owner, d.Repo, d.SHA)
+}
+
+type DeploymentStore interface {
+	Save(deployment Deployment) error
+	Get(id string) (Deployment, error)
+	// Running lists the queued and running deployments of every instance.
+	Running() ([]Deployment, error)
+	// Heartbeat records that the instance is alive. LastHeartbeat returns
+	// the zero time for an instance that never sent one.
+	Heartbeat(instance string) error
+	LastHeartbeat(instance string) (time.Time, error)
+	// Prune deletes deployments that finished before cutoff.
+	Prune(cutoff time.Time) error
+}
+
+// NewDeploymentStore builds the store selected by DEPLOYMENT_STORE, which
+// defaults to the session store's backend. Cookies can't hold deployments,
+// so the cookie session store falls back to the filesystem.
+func NewDeploymentStore(config Config) (DeploymentStore, error) {
+	backend, url := config.DeploymentStore, config.DeploymentStoreURL
+	if backend == "" {
+		backend, url = config.SessionStore, config.SessionStoreURL
+		if backend == "cookie" {
+			backend, url = "filesystem", ""
+		}
+	}
+
+	switch backend {
+	case "filesystem":
+		if url == "" {
+			url = filepath.Join(os.TempDir(), "deploy-to-cf-deployments")
+		}
+		return NewFileDeploymentStore(url)
+	case "redis":
+		return &RedisDeploymentStore{pool: NewRedisPool(url), retention: time.Duration(config.DeploymentRetention) * 24 * time.Hour}, nil
+	case "sql":
+		db, err := sql.Open("postgres", url)
+		if err != nil {
+			return nil, err
+		}
+		return NewSQLDeploymentStore(db)
+	}
+	return nil, fmt.Errorf("Unknown deployment store %q", backend)
+}
+
+// InterruptDeployments marks unfinished deployments failed when the instance
+// running them has stopped: either it is the given instance, or it hasn't
+// sent a heartbeat within lease. Deployments recorded without an instance
+// have no one to finish them. The errors list the services created so far,
+// so that users can clean them up.
+func InterruptDeployments(store DeploymentStore, stopped string, lease time.Duration) ([]Deployment, error) {
+	running, err := store.Running()
+	if err != nil {
+		return nil, err
+	}
+
+	dead := map[string]bool{"": true}
+	if stopped != "" {
+		dead[stopped] = true
+	}
+	interrupted := []Deployment{}
+	for _, deployment := range running {
+		isDead, ok := dead[deployment.Instance]
+		if !ok {
+			heartbeat, err := store.LastHeartbeat(deployment.Instance)
+			if err != nil {
+				return nil, err
+			}
+			isDead = time.Since(heartbeat) > lease
+			dead[deployment.Instance] = isDead
+		}
+		if isDead {
+			interrupted = append(interrupted, deployment)
+		}
+	}
+
+	for idx := range interrupted {
+		deployment := &interrupted[idx]
+		deployment.Status = DeploymentFailed
+		deployment.Error = fmt.Sprintf("The deployment was interrupted by a restart (phase: %s).", deployment.Phase)
+		if len(deployment.Services) > 0 {
+			deployment.Error += " Services created so far: " + strings.Join(deployment.Services, ", ") + "."
+		}
+		deployment.Updated = time.Now()
+		if err := store.Save(*deployment); err != nil {
+			return nil, err
+		}
+	}
+	return interrupted, nil
+}
+
+// FileDeploymentStore keeps a JSON file per deployment. It only survives
+// restarts where the filesystem does, which on Cloud Foundry it doesn't.
+type FileDeploymentStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func NewFileDeploymentStore(dir string) (*FileDeploymentStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileDeploymentStore{dir: dir}, nil
+}
+
+func (s *FileDeploymentStore) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
+
+func (s *FileDeploymentStore) Save(deployment Deployment) error {
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := s.path(deployment.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(deployment.ID))
+}
+
+func (s *FileDeploymentStore) Get(id string) (Deployment, error) {
+	data, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return Deployment{}, ErrDeploymentNotFound
+	}
+	if err != nil {
+		return Deployment{}, err
+	}
+	deployment := Deployment{}
+	err = json.Unmarshal(data, &deployment)
+	return deployment, err
+}
+
+func (s *FileDeploymentStore) all() ([]Deployment, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	deployments := []Deployment{}
+	for _, path := range paths {
+		deployment, err := s.Get(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, deployment)
+	}
+	return deployments, nil
+}
+
+func (s *FileDeploymentStore) Running() ([]Deployment, error) {
+	deployments, err := s.all()
+	if err != nil {
+		return nil, err
+	}
+	running := []Deployment{}
+	for _, deployment := range deployments {
+		if !deployment.Finished() {
+			running = append(running, deployment)
+		}
+	}
+	return running, nil
+}
+
+func (s *FileDeploymentStore) heartbeatPath(instance string) string {
+	return filepath.Join(s.dir, filepath.Base(instance)+".heartbeat")
+}
+
+func (s *FileDeploymentStore) Heartbeat(instance string) error {
+	return ioutil.WriteFile(s.heartbeatPath(instance), []byte(time.Now().Format(time.RFC3339)), 0600)
+}
+
+func (s *FileDeploymentStore) LastHeartbeat(instance string) (time.Time, error) {
+	data, err := ioutil.ReadFile(s.heartbeatPath(instance))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, string(data))
+}
+
+func (s *FileDeploymentStore) Prune(cutoff time.Time) error {
+	deployments, err := s.all()
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments {
+		if deployment.Finished() && deployment.Updated.Before(cutoff) {
+			if err := os.Remove(s.path(deployment.ID)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RedisDeploymentStore keeps each deployment under deployment:<id>, and the
+// IDs of running ones in a set. Finished deployments expire after the
+// retention period, so Prune has nothing to do.
+type RedisDeploymentStore struct {
+	pool      *redis.Pool
+	retention time.Duration
+}
+
+const runningKey = "deployments:running"
+
+func (s *RedisDeploymentStore) Save(deployment Deployment) error {
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	if !deployment.Finished() {
+		conn.Send("SET", "deployment:"+deployment.ID, data)
+		conn.Send("SADD", runningKey, deployment.ID)
+	} else {
+		conn.Send("SET", "deployment:"+deployment.ID, data, "EX", int(s.retention.Seconds()))
+		conn.Send("SREM", runningKey, deployment.ID)
+	}
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+func (s *RedisDeploymentStore) Get(id string) (Deployment, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", "deployment:"+id))
+	if err == redis.ErrNil {
+		return Deployment{}, ErrDeploymentNotFound
+	}
+	if err != nil {
+		return Deployment{}, err
+	}
+	deployment := Deployment{}
+	err = json.Unmarshal(data, &deployment)
+	return deployment, err
+}
+
+func (s *RedisDeploymentStore) Running() ([]Deployment, error) {
+	conn := s.pool.Get()
+	ids, err := redis.Strings(conn.Do("SMEMBERS", runningKey))
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	running := []Deployment{}
+	for _, id := range ids {
+		deployment, err := s.Get(id)
+		if err == ErrDeploymentNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		running = append(running, deployment)
+	}
+	return running, nil
+}
+
+// Heartbeats expire long after any lease, so that the keys of stopped
+// instances don't pile up.
+func (s *RedisDeploymentStore) Heartbeat(instance string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", "deployments:heartbeat:"+instance, time.Now().Unix(), "EX", 24*60*60)
+	return err
+}
+
+func (s *RedisDeploymentStore) LastHeartbeat(instance string) (time.Time, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	seconds, err := redis.Int64(conn.Do("GET", "deployments:heartbeat:"+instance))
+	if err == redis.ErrNil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+func (s *RedisDeploymentStore) Prune(cutoff time.Time) error {
+	return nil
+}
+
+// SQLDeploymentStore keeps deployments in a PostgreSQL table.
+type SQLDeploymentStore struct {
+	db *sql.DB
+}
+
+func NewSQLDeploymentStore(db *sql.DB) (*SQLDeploymentStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS deployments (
+		id TEXT PRIMARY KEY,
+		instance TEXT NOT NULL,
+		status TEXT NOT NULL,
+		data TEXT NOT NULL,
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS deployment_heartbeats (
+		instance TEXT PRIMARY KEY,
+		heartbeat TIMESTAMP WITH TIME ZONE NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLDeploymentStore{db: db}, nil
+}
+
+func (s *SQLDeploymentStore) Save(deployment Deployment) error {
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO deployments (id, instance, status, data, updated_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET instance = $2, status = $3, data = $4, updated_at = $5`,
+		deployment.ID, deployment.Instance, deployment.Status, data, deployment.Updated,
+	)
+	return err
+}
+
+func (s *SQLDeploymentStore) Get(id string) (Deployment, error) {
+	var data string
+	err := s.db.QueryRow("SELECT data FROM deployments WHERE id = $1", id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Deployment{}, ErrDeploymentNotFound
+	}
+	if err != nil {
+		return Deployment{}, err
+	}
+	deployment := Deployment{}
+	err = json.Unmarshal([]byte(data), &deployment)
+	return deployment, err
+}
+
+func (s *SQLDeploymentStore) Running() ([]Deployment, error) {
+	rows, err := s.db.Query(
+		"SELECT data FROM deployments WHERE status IN ($1, $2)",
+		DeploymentQueued, DeploymentRunning,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	running := []Deployment{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		deployment := Deployment{}
+		if err := json.Unmarshal([]byte(data), &deployment); err != nil {
+			return nil, err
+		}
+		running = append(running, deployment)
+	}
+	return running, rows.Err()
+}
+
+func (s *SQLDeploymentStore) Heartbeat(instance string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO deployment_heartbeats (instance, heartbeat) VALUES ($1, $2)
+		ON CONFLICT (instance) DO UPDATE SET heartbeat = $2`,
+		instance, time.Now(),
+	)
+	return err
+}
+
+func (s *SQLDeploymentStore) LastHeartbeat(instance string) (time.Time, error) {
+	var heartbeat time.Time
+	err := s.db.QueryRow("SELECT heartbeat FROM deployment_heartbeats WHERE instance = $1", instance).Scan(&heartbeat)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return heartbeat, err
+}
+
+// Prune also forgets instances that have been silent for as long.
+func (s *SQLDeploymentStore) Prune(cutoff time.Time) error {
+	_, err := s.db.Exec(
+		"DELETE FROM deployments WHERE status IN ($1, $2) AND updated_at < $3",
+		DeploymentSucceeded, DeploymentFailed, cutoff,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("DELETE FROM deployment_heartbeats WHERE heartbeat < $1", cutoff)
+	return err
+}
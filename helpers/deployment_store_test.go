@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestInterruptDeployments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deployments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewFileDeploymentStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The draining instance is still sending heartbeats; the crashed one stopped.
+	store.Heartbeat("draining")
+	store.Save(Deployment{ID: "a", Instance: "draining", Status: DeploymentRunning, Phase: "push"})
+	store.Save(Deployment{ID: "b", Instance: "crashed", Status: DeploymentRunning, Phase: "services", Services: []string{"db"}})
+	store.Save(Deployment{ID: "c", Instance: "crashed", Status: DeploymentSucceeded, Updated: time.Now().Add(-48 * time.Hour)})
+	// Even if something heartbeats without an instance, its deployments can't be told apart.
+	store.Heartbeat("")
+	store.Save(Deployment{ID: "d", Status: DeploymentRunning, Phase: "push"})
+
+	interrupted, err := InterruptDeployments(store, "", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interrupted) != 2 || interrupted[0].ID == "a" || interrupted[1].ID == "a" {
+		t.Fatalf("interrupted %v, expected b and d", interrupted)
+	}
+	if deployment, _ := store.Get("b"); deployment.Status != DeploymentFailed {
+		t.Errorf("b is %s, expected failed", deployment.Status)
+	}
+	if deployment, _ := store.Get("a"); deployment.Status != DeploymentRunning {
+		t.Errorf("a is %s, expected running", deployment.Status)
+	}
+
+	// Once the draining instance gives up, its own deployments fail too.
+	interrupted, err = InterruptDeployments(store, "draining", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interrupted) != 1 || interrupted[0].ID != "a" {
+		t.Fatalf("interrupted %v, expected only a", interrupted)
+	}
+
+	if err := store.Prune(time.Now().Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("c"); err != ErrDeploymentNotFound {
+		t.Errorf("expected c to be pruned, got %v", err)
+	}
+	if _, err := store.Get("b"); err != nil {
+		t.Errorf("expected b to be kept, got %v", err)
+	}
+}
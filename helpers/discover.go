@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type infoResponse struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type link struct {
+	Href string `json:"href"`
+}
+
+type rootResponse struct {
+	Links struct {
+		Login link `json:"login"`
+		UAA   link `json:"uaa"`
+	} `json:"links"`
+}
+
+// DiscoverEndpoints fills in the authorization and token URLs the operator
+// didn't set from the Cloud Controller, then checks that both respond.
+func DiscoverEndpoints(client *http.Client, foundation Foundation) (Foundation, error) {
+	if foundation.AuthURL == "" || foundation.TokenURL == "" {
+		authURL, tokenURL, err := fetchEndpoints(client, foundation.CFURL)
+		if err != nil {
+			return foundation, fmt.Errorf("Could not discover UAA endpoints for %s from %s: %s", foundation.Name, foundation.CFURL, err)
+		}
+		if foundation.AuthURL == "" {
+			foundation.AuthURL = authURL
+		}
+		if foundation.TokenURL == "" {
+			foundation.TokenURL = tokenURL
+		}
+	}
+
+	for _, endpoint := range []string{foundation.AuthURL, foundation.TokenURL} {
+		if err := checkEndpoint(client, endpoint+"/info"); err != nil {
+			return foundation, fmt.Errorf("UAA endpoint %s for %s is not responding: %s", endpoint, foundation.Name, err)
+		}
+	}
+	return foundation, nil
+}
+
+func fetchEndpoints(client *http.Client, cfURL string) (string, string, error) {
+	info := infoResponse{}
+	v2Err := getJSON(client, cfURL+"/v2/info", &info)
+	if v2Err == nil && info.AuthorizationEndpoint != "" && info.TokenEndpoint != "" {
+		return strings.TrimSuffix(info.AuthorizationEndpoint, "/"), strings.TrimSuffix(info.TokenEndpoint, "/"), nil
+	}
+
+	root := rootResponse{}
+	if err := getJSON(client, cfURL+"/", &root); err != nil {
+		if v2Err != nil {
+			return "", "", v2Err
+		}
+		return "", "", err
+	}
+	if root.Links.Login.Href == "" || root.Links.UAA.Href == "" {
+		return "", "", fmt.Errorf("No login or uaa links in %s", cfURL)
+	}
+	return strings.TrimSuffix(root.Links.Login.Href, "/"), strings.TrimSuffix(root.Links.UAA.Href, "/"), nil
+}
+
+func checkEndpoint(client *http.Client, url string) error {
+	return getJSON(client, url, &map[string]interface{}{})
+}
+
+func getJSON(client *http.Client, url string, value interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package helpers
+
+import "syscall"
+
+// FreeSpace returns the bytes available to unprivileged users under path.
+func FreeSpace(path string) (int64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
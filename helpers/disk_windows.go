@@ -0,0 +1,6 @@
+package helpers
+
+// FreeSpace isn't implemented on Windows; -1 means unknown.
+func FreeSpace(path string) (int64, error) {
+	return -1, nil
+}
@@ -3,13 +3,15 @@ package helpers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 )
 
-type OrgResponse struct {
-	NextURL   string `json:"next_url"`
-	Resources []Org  `json:"resources"`
-}
+// maxPageRequests bounds how many pages of a single list are requested at once.
+const maxPageRequests = 4
 
 type Org struct {
 	Meta struct {
@@ -20,11 +22,6 @@ type Org struct {
 	} `json:"entity"`
 }
 
-type SpaceResponse struct {
-	NextURL   string  `json:"next_url"`
-	Resources []Space `json:"resources"`
-}
-
 type Space struct {
 	Meta struct {
 		GUID string `json:"guid"`
@@ -36,77 +33,139 @@ type Space struct {
 	} `json:"entity"`
 }
 
-func FetchOrgs(client *http.Client, config Config) ([]Org, error) {
+type listPage struct {
+	TotalPages int             `json:"total_pages"`
+	Resources  json.RawMessage `json:"resources"`
+}
+
+func FetchOrgs(client *http.Client, foundation Foundation, resultsPerPage int) ([]Org, error) {
 	orgs := []Org{}
-	pageURL := "/v2/organizations"
-	for {
-		page, err := fetchOrganizationsPage(client, config.CFURL+pageURL)
-		if err != nil {
-			return []Org{}, err
-		}
-		orgs = append(orgs, page.Resources...)
-		pageURL = page.NextURL
-		if pageURL == "" {
-			break
+	err := fetchResources(client, foundation.CFURL+"/v2/organizations", url.Values{}, resultsPerPage, func(resources json.RawMessage) error {
+		page := []Org{}
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
 		}
+		orgs = append(orgs, page...)
+		return nil
+	})
+	if err != nil {
+		return []Org{}, err
 	}
 	return orgs, nil
 }
 
-func FetchSpaces(client *http.Client, config Config) ([]Space, error) {
+// ErrNoUserID means the access token has no user ID, so the spaces the user
+// may deploy to can't be told apart from the ones they can only see.
+var ErrNoUserID = errors.New("The access token has no user ID")
+
+// FetchSpaces lists the spaces where the user is a SpaceDeveloper, since
+// those are the only spaces a push can succeed in.
+func FetchSpaces(client *http.Client, foundation Foundation, userID string, resultsPerPage int) ([]Space, error) {
+	if userID == "" {
+		return []Space{}, ErrNoUserID
+	}
+	query := url.Values{}
+	query.Set("q", "developer_guid:"+userID)
+
 	spaces := []Space{}
-	pageURL := "/v2/spaces"
-	for {
-		page, err := fetchSpacesPage(client, config.CFURL+pageURL)
-		if err != nil {
-			return []Space{}, err
-		}
-		spaces = append(spaces, page.Resources...)
-		pageURL = page.NextURL
-		if pageURL == "" {
-			break
+	err := fetchResources(client, foundation.CFURL+"/v2/spaces", query, resultsPerPage, func(resources json.RawMessage) error {
+		page := []Space{}
+		if err := json.Unmarshal(resources, &page); err != nil {
+			return err
 		}
+		spaces = append(spaces, page...)
+		return nil
+	})
+	if err != nil {
+		return []Space{}, err
 	}
 	return spaces, nil
 }
 
-func fetchOrganizationsPage(client *http.Client, url string) (OrgResponse, error) {
-	resp, err := client.Get(url)
+// fetchResources requests every page of a v2 list. The first page tells us
+// how many there are, so the rest are fetched concurrently; handlePage still
+// sees the pages one at a time and in order.
+func fetchResources(client *http.Client, listURL string, query url.Values, resultsPerPage int, handlePage func(json.RawMessage) error) error {
+	pageURL := func(number int) string {
+		params := url.Values{}
+		for key, values := range query {
+			params[key] = values
+		}
+		params.Set("page", strconv.Itoa(number))
+		params.Set("results-per-page", strconv.Itoa(resultsPerPage))
+		return listURL + "?" + params.Encode()
+	}
+
+	first, err := fetchPage(client, pageURL(1))
 	if err != nil {
-		return OrgResponse{}, err
+		return err
+	}
+	if err := handlePage(first.Resources); err != nil {
+		return err
+	}
+	if first.TotalPages <= 1 {
+		return nil
 	}
 
-	defer resp.Body.Close()
-	page := OrgResponse{}
-	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
-		return OrgResponse{}, errors.New("")
+	pages := make([]listPage, first.TotalPages+1)
+	errs := make([]error, first.TotalPages+1)
+	limit := make(chan struct{}, maxPageRequests)
+	wg := sync.WaitGroup{}
+	for number := 2; number <= first.TotalPages; number++ {
+		wg.Add(1)
+		go func(number int) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			pages[number], errs[number] = fetchPage(client, pageURL(number))
+		}(number)
 	}
+	wg.Wait()
 
-	return page, nil
+	for number := 2; number <= first.TotalPages; number++ {
+		if errs[number] != nil {
+			return errs[number]
+		}
+		if err := handlePage(pages[number].Resources); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func fetchSpacesPage(client *http.Client, url string) (SpaceResponse, error) {
-	resp, err := client.Get(url)
+func fetchPage(client *http.Client, pageURL string) (listPage, error) {
+	resp, err := client.Get(pageURL)
 	if err != nil {
-		return SpaceResponse{}, err
+		return listPage{}, err
 	}
 
 	defer resp.Body.Close()
-	page := SpaceResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return listPage{}, NewCCError(resp)
+	}
+
+	page := listPage{}
 	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
-		return SpaceResponse{}, errors.New("")
+		return listPage{}, fmt.Errorf("Invalid response from %s: %s", pageURL, err)
 	}
 
 	return page, nil
 }
 
-func FetchTargets(client *http.Client, config Config) ([]Space, error) {
-	orgs, err := FetchOrgs(client, config)
-	if err != nil {
-		return []Space{}, err
+func FetchTargets(client *http.Client, foundation Foundation, userID string, resultsPerPage int) ([]Space, error) {
+	var orgs []Org
+	var orgsErr error
+	done := make(chan struct{})
+	go func() {
+		orgs, orgsErr = FetchOrgs(client, foundation, resultsPerPage)
+		close(done)
+	}()
+
+	spaces, err := FetchSpaces(client, foundation, userID, resultsPerPage)
+	<-done
+	if orgsErr != nil {
+		return []Space{}, orgsErr
 	}
-
-	spaces, err := FetchSpaces(client, config)
 	if err != nil {
 		return []Space{}, err
 	}
@@ -0,0 +1,28 @@
+package helpers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchSpacesFiltersByDeveloper(t *testing.T) {
+	queries := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("q"))
+		fmt.Fprint(w, `{"total_pages": 1, "resources": []}`)
+	}))
+	defer server.Close()
+	foundation := Foundation{CFURL: server.URL}
+
+	if _, err := FetchSpaces(http.DefaultClient, foundation, "", 50); err != ErrNoUserID {
+		t.Errorf("expected ErrNoUserID without a user ID, got %v", err)
+	}
+	if _, err := FetchSpaces(http.DefaultClient, foundation, "user-1", 50); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || queries[0] != "developer_guid:user-1" {
+		t.Errorf("got queries %v", queries)
+	}
+}
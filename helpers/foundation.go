@@ -0,0 +1,87 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"golang.org/x/oauth2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type Foundation struct {
+	Name         string `yaml:"name"`
+	Label        string `yaml:"label"`
+	CFURL        string `yaml:"cf_url"`
+	AuthURL      string `yaml:"auth_url"`
+	TokenURL     string `yaml:"token_url"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+}
+
+type foundationsFile struct {
+	Foundations []Foundation `yaml:"foundations"`
+}
+
+// LoadFoundations reads the foundations listed in FOUNDATIONS_FILE, or falls
+// back to a single foundation built from the CF_URL, AUTH_URL, etc. settings.
+// Auth and token URLs may be left empty for DiscoverEndpoints to fill in.
+func LoadFoundations(config Config) ([]Foundation, error) {
+	foundations := []Foundation{{
+		Name:         "default",
+		Label:        "Cloud Foundry",
+		CFURL:        config.CFURL,
+		AuthURL:      config.AuthURL,
+		TokenURL:     config.TokenURL,
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+	}}
+
+	if config.FoundationsFile != "" {
+		data, err := ioutil.ReadFile(config.FoundationsFile)
+		if err != nil {
+			return nil, err
+		}
+		file := foundationsFile{}
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		foundations = file.Foundations
+	}
+
+	if len(foundations) == 0 {
+		return nil, errors.New("No foundations configured")
+	}
+
+	names := map[string]bool{}
+	for idx, foundation := range foundations {
+		if foundation.Name == "" {
+			return nil, fmt.Errorf("Foundation %d has no name", idx)
+		}
+		if names[foundation.Name] {
+			return nil, fmt.Errorf("Duplicate foundation %s", foundation.Name)
+		}
+		names[foundation.Name] = true
+		if foundation.CFURL == "" || foundation.ClientID == "" || foundation.ClientSecret == "" {
+			return nil, fmt.Errorf("Foundation %s is missing its API URL or client credentials", foundation.Name)
+		}
+		if foundation.Label == "" {
+			foundations[idx].Label = foundation.Name
+		}
+	}
+
+	return foundations, nil
+}
+
+func (f Foundation) OauthConfig(hostname string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     f.ClientID,
+		ClientSecret: f.ClientSecret,
+		RedirectURL:  hostname + "/callback",
+		Scopes:       []string{"cloud_controller.read", "cloud_controller.write", "cloud_controller.admin", "openid"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  f.AuthURL + "/oauth/authorize",
+			TokenURL: f.TokenURL + "/oauth/token",
+		},
+	}
+}
@@ -0,0 +1,147 @@
+package helpers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HealthCheck is the result of one readiness check.
+type HealthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// readyTTL is how long readiness results are reused. Anyone can ask for
+// them, and each run calls every foundation and writes to the session store.
+const readyTTL = 5 * time.Second
+
+var readiness struct {
+	sync.Mutex
+	checked time.Time
+	ready   bool
+	results []HealthCheck
+}
+
+// Ready runs the readiness checks concurrently, or returns the results of
+// the last run if it was recent. Each page template is parsed together with
+// layout, the way it's rendered. Failures are logged; the results only say
+// which checks failed.
+func (c *Context) Ready(pages []string, layout string) (bool, []HealthCheck) {
+	readiness.Lock()
+	defer readiness.Unlock()
+	if time.Since(readiness.checked) < readyTTL {
+		return readiness.ready, readiness.results
+	}
+	readiness.ready, readiness.results = c.runChecks(pages, layout)
+	readiness.checked = time.Now()
+	return readiness.ready, readiness.results
+}
+
+func (c *Context) runChecks(pages []string, layout string) (bool, []HealthCheck) {
+	checks := map[string]func() error{
+		"session_store": c.checkSessionStore,
+		"templates": func() error {
+			return checkTemplates(pages, layout)
+		},
+		"cf_cli": func() error {
+			_, err := exec.LookPath("cf")
+			return err
+		},
+		"temp_dir": func() error {
+			return checkFreeSpace(os.TempDir(), c.Config.MaxArchiveSize)
+		},
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, foundation := range c.Foundations {
+		foundation := foundation
+		checks["cc:"+foundation.Name] = func() error {
+			return checkEndpoint(client, foundation.CFURL+"/v2/info")
+		}
+		checks["uaa:"+foundation.Name] = func() error {
+			return checkEndpoint(client, foundation.AuthURL+"/info")
+		}
+	}
+
+	results := make([]HealthCheck, 0, len(checks))
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func() error) {
+			defer wg.Done()
+			result := HealthCheck{Name: name, OK: true}
+			if err := check(); err != nil {
+				log.WithError(err).WithField("check", name).Warn("Readiness check failed")
+				result.OK = false
+				result.Error = "unavailable"
+			}
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	ready := true
+	for _, result := range results {
+		ready = ready && result.OK
+	}
+	return ready, results
+}
+
+// checkSessionStore saves a throwaway session and then deletes it, which
+// works the same way for every backend.
+func (c *Context) checkSessionStore() error {
+	r, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		return err
+	}
+	session, err := c.Store.New(r, "readyz")
+	if err != nil && session == nil {
+		return err
+	}
+	session.Values["checked"] = time.Now().Unix()
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		return err
+	}
+
+	options := *session.Options
+	options.MaxAge = -1
+	session.Options = &options
+	return session.Save(r, httptest.NewRecorder())
+}
+
+func checkTemplates(pages []string, layout string) error {
+	for _, page := range pages {
+		if _, err := template.ParseFiles(page, layout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFreeSpace wants room for at least one archive of the largest size
+// allowed.
+func checkFreeSpace(path string, needed int64) error {
+	free, err := FreeSpace(path)
+	if err != nil {
+		return err
+	}
+	if free >= 0 && free < needed {
+		return fmt.Errorf("%s has %d bytes free, need %d", path, free, needed)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestReadyIsCachedAndGeneric(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "secret internal detail", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	readiness.checked = time.Time{}
+	c := &Context{
+		Store:       sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef")),
+		Foundations: []Foundation{{Name: "test", CFURL: server.URL, AuthURL: server.URL}},
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		ready, checks := c.Ready(nil, "")
+		if ready {
+			t.Error("expected the foundation's checks to fail")
+		}
+		for _, check := range checks {
+			if !check.OK && check.Error != "unavailable" {
+				t.Errorf("%s: got error %q", check.Name, check.Error)
+			}
+		}
+	}
+	if requests != 2 {
+		t.Errorf("expected one run of the cc and uaa checks, got %d requests", requests)
+	}
+}
@@ -0,0 +1,39 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+const maxHistory = 20
+
+// HistoryEntry records a deployment for the user's history page. Route and
+// Error are filled in from the deployment store when the page is shown.
+type HistoryEntry struct {
+	DeploymentID string
+	Status       string
+	Owner        string
+	Repo         string
+	Ref          string
+	SHA          string
+	Foundation   string
+	OrgName      string
+	SpaceName    string
+	Route        string
+	Error        string
+	Time         time.Time
+}
+
+func History(session *sessions.Session) []HistoryEntry {
+	history, _ := session.Values["history"].([]HistoryEntry)
+	return history
+}
+
+func AddHistory(session *sessions.Session, entry HistoryEntry) {
+	history := append([]HistoryEntry{entry}, History(session)...)
+	if len(history) > maxHistory {
+		history = history[:maxHistory]
+	}
+	session.Values["history"] = history
+}
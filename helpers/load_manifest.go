@@ -2,18 +2,38 @@ package helpers
 
 import (
 	"context"
+	"io/ioutil"
+	"regexp"
+	"strings"
 
 	"github.com/google/go-github/github"
+	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
 )
 
+var shaPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
 type AppWrapper struct {
-	Deployment App `yaml:"deployment"`
+	AppSettings  `yaml:",inline"`
+	Applications []AppSettings `yaml:"applications"`
+	Deployment   App           `yaml:"deployment"`
 }
 
 type App struct {
 	EnvVars  map[string]*EnvVar `yaml:"env"`
 	Services []Service          `yaml:"services"`
+	Strategy string             `yaml:"strategy"`
+	Apps     []AppSettings      `yaml:"-"`
+}
+
+// AppSettings are the resources a pushed application asks for.
+type AppSettings struct {
+	Name      string `yaml:"name"`
+	Memory    string `yaml:"memory"`
+	Instances int    `yaml:"instances"`
+	Buildpack string `yaml:"buildpack"`
+	Routes    []string
+	Env       map[string]string
 }
 
 type Service struct {
@@ -30,22 +50,69 @@ type EnvVar struct {
 	Value       string `yaml:"value"`
 }
 
-func LoadManifest(client *github.Client, owner, repo, ref string) (App, error) {
+func LoadManifest(client *github.Client, cache Cache, owner, repo, ref string) (App, error) {
 	wrapper := AppWrapper{}
 
+	raw, err := FetchManifest(client, cache, owner, repo, ref)
+	if err != nil {
+		return App{}, err
+	}
+
+	if err := yaml.Unmarshal(raw, &wrapper); err != nil {
+		return App{}, err
+	}
+
+	// Settings at the top of the manifest apply to every application.
+	app := wrapper.Deployment
+	for _, settings := range wrapper.Applications {
+		if settings.Memory == "" {
+			settings.Memory = wrapper.Memory
+		}
+		if settings.Instances == 0 {
+			settings.Instances = wrapper.Instances
+		}
+		if settings.Instances == 0 {
+			settings.Instances = 1
+		}
+		if settings.Buildpack == "" {
+			settings.Buildpack = wrapper.Buildpack
+		}
+		app.Apps = append(app.Apps, settings)
+	}
+	return app, nil
+}
+
+// FetchManifest reads manifest.yml from the repository. A commit never
+// changes, so when ref is a SHA the manifest is served from the cache if
+// there is one.
+func FetchManifest(client *github.Client, cache Cache, owner, repo, ref string) ([]byte, error) {
+	key := CacheKey("github", owner, repo, ref, "manifest.yml")
+	if cache != nil && IsCommitSHA(ref) {
+		if reader, err := cache.Get(key); err == nil {
+			defer reader.Close()
+			return ioutil.ReadAll(reader)
+		}
+	}
+
 	opts := &github.RepositoryContentGetOptions{Ref: ref}
 	content, _, _, err := client.Repositories.GetContents(context.Background(), owner, repo, "manifest.yml", opts)
 	if err != nil {
-		return App{}, err
+		return nil, err
 	}
 
 	raw, err := content.GetContent()
 	if err != nil {
-		return App{}, err
+		return nil, err
 	}
 
-	if err := yaml.Unmarshal([]byte(raw), &wrapper); err != nil {
-		return App{}, err
+	if cache != nil && IsCommitSHA(ref) {
+		if err := cache.Put(key, strings.NewReader(raw)); err != nil {
+			log.WithError(err).Warn("Error caching manifest")
+		}
 	}
-	return wrapper.Deployment, nil
+	return []byte(raw), nil
+}
+
+func IsCommitSHA(ref string) bool {
+	return shaPattern.MatchString(ref)
 }
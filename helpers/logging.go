@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	stdlog "log"
+	"net/http"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type contextKey int
+
+const loggerKey contextKey = iota
+
+// ConfigureLogging makes the standard logger, which the helpers and the
+// standard library log through, write JSON at the configured level.
+func ConfigureLogging(config Config) (*log.Logger, error) {
+	level, err := log.ParseLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	log.SetFormatter(&log.JSONFormatter{})
+	log.SetOutput(os.Stdout)
+	log.SetLevel(level)
+
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.StandardLogger().WriterLevel(log.WarnLevel))
+	return log.StandardLogger(), nil
+}
+
+// NewID returns a random identifier for requests and deployments.
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// LogRequests gives each request an ID, reusing the one the Cloud Foundry
+// router assigned, and a logger carrying it. Only the path is logged, since
+// query strings can hold OAuth codes.
+func (c *Context) LogRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Vcap-Request-Id")
+		if id == "" {
+			id = NewID()
+		}
+		w.Header().Set("X-Request-Id", id)
+		entry := c.Logger.WithField("request_id", id)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), loggerKey, entry)))
+
+		entry.WithFields(log.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      sw.status,
+			"duration_ms": time.Since(start).Nanoseconds() / int64(time.Millisecond),
+		}).Info("Handled request")
+	})
+}
+
+// Log returns the logger for r, carrying its request ID.
+func (c *Context) Log(r *http.Request) *log.Entry {
+	if entry, ok := r.Context().Value(loggerKey).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(c.Logger)
+}
@@ -1,7 +1,11 @@
 package helpers
 
 import (
+	"fmt"
 	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 )
@@ -35,6 +39,163 @@ func (manifest *Manifest) EnvironmentVariables() map[interface{}]interface{} {
 	return envVars
 }
 
+// Applications reads the settings of each application, applying the ones
+// given at the top of the manifest to all of them.
+func (manifest *Manifest) Applications() ([]AppSettings, error) {
+	defaults, err := appSettings(manifest.data)
+	if err != nil {
+		return nil, err
+	}
+	apps, ok := manifest.data["applications"].([]interface{})
+	if !ok {
+		apps = []interface{}{manifest.data}
+	}
+
+	settings := []AppSettings{}
+	for _, raw := range apps {
+		data, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		app, err := appSettings(data)
+		if err != nil {
+			return nil, err
+		}
+		if app.Memory == "" {
+			app.Memory = defaults.Memory
+		}
+		if app.Instances == 0 {
+			app.Instances = defaults.Instances
+		}
+		if app.Instances == 0 {
+			app.Instances = 1
+		}
+		if app.Buildpack == "" {
+			app.Buildpack = defaults.Buildpack
+		}
+		if app.Routes == nil {
+			app.Routes = defaults.Routes
+		}
+		// The cf CLI merges the top-level env into each application's.
+		for name, value := range defaults.Env {
+			if _, ok := app.Env[name]; !ok {
+				app.Env[name] = value
+			}
+		}
+		settings = append(settings, app)
+	}
+	return settings, nil
+}
+
+func appSettings(data map[interface{}]interface{}) (AppSettings, error) {
+	settings := AppSettings{}
+	if name, ok := data["name"]; ok {
+		settings.Name = fmt.Sprint(name)
+	}
+	if memory, ok := data["memory"]; ok {
+		settings.Memory = fmt.Sprint(memory)
+	}
+	if instances, ok := data["instances"]; ok {
+		count, err := parseInstances(instances)
+		if err != nil {
+			return AppSettings{}, err
+		}
+		settings.Instances = count
+	}
+	if buildpack, ok := data["buildpack"].(string); ok {
+		settings.Buildpack = buildpack
+	}
+	settings.Env = map[string]string{}
+	if env, ok := data["env"].(map[interface{}]interface{}); ok {
+		for name, value := range env {
+			if value != nil {
+				settings.Env[fmt.Sprint(name)] = fmt.Sprint(value)
+			}
+		}
+	}
+	if routes, ok := data["routes"].([]interface{}); ok {
+		for _, raw := range routes {
+			if route, ok := raw.(map[interface{}]interface{}); ok && route["route"] != nil {
+				settings.Routes = append(settings.Routes, fmt.Sprint(route["route"]))
+			}
+		}
+	}
+	return settings, nil
+}
+
+// parseInstances reads an instance count, which the cf CLI also accepts
+// quoted or written as a float.
+func parseInstances(value interface{}) (int, error) {
+	count := -1
+	switch v := value.(type) {
+	case int:
+		count = v
+	case int64:
+		if v == int64(int(v)) {
+			count = int(v)
+		}
+	case float64:
+		if v == math.Trunc(v) && v <= math.MaxInt32 {
+			count = int(v)
+		}
+	case string:
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			count = n
+		}
+	}
+	if count < 0 {
+		return 0, fmt.Errorf("Invalid number of instances in manifest.yml: %v", value)
+	}
+	return count, nil
+}
+
+// ForApp copies the manifest, keeping only the application called name and
+// renaming it to newName.
+func (manifest *Manifest) ForApp(name, newName string) Manifest {
+	data := map[interface{}]interface{}{}
+	for key, value := range manifest.data {
+		data[key] = value
+	}
+
+	apps, ok := manifest.data["applications"].([]interface{})
+	if !ok {
+		data["name"] = newName
+		return Manifest{data: data}
+	}
+	for _, raw := range apps {
+		app, ok := raw.(map[interface{}]interface{})
+		if !ok || fmt.Sprint(app["name"]) != name {
+			continue
+		}
+		copied := map[interface{}]interface{}{}
+		for key, value := range app {
+			copied[key] = value
+		}
+		copied["name"] = newName
+		data["applications"] = []interface{}{copied}
+	}
+	return Manifest{data: data}
+}
+
+// ForExistingApp points the manifest at the app called name, which may have
+// been renamed since it was pushed: a single app is renamed to name, and a
+// manifest with several apps must already include it.
+func (manifest *Manifest) ForExistingApp(name string) (Manifest, error) {
+	apps, err := manifest.Applications()
+	if err != nil {
+		return Manifest{}, err
+	}
+	if len(apps) == 1 {
+		return manifest.ForApp(apps[0].Name, name), nil
+	}
+	for _, app := range apps {
+		if app.Name == name {
+			return *manifest, nil
+		}
+	}
+	return Manifest{}, &PolicyError{fmt.Sprintf("manifest.yml has several apps, and none of them is %s.", name)}
+}
+
 func (manifest *Manifest) AddEnvironmentVariable(name, value string) {
 	manifest.EnvironmentVariables()[name] = value
 }
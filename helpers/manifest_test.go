@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestApplicationsInstances(t *testing.T) {
+	cases := map[string]int{
+		"instances: 50":     50,
+		"instances: \"50\"": 50,
+		"instances: 50.0":   50,
+		"memory: 64M":       1,
+	}
+	for data, expected := range cases {
+		manifest := Manifest{}
+		if err := yaml.Unmarshal([]byte(data), &manifest.data); err != nil {
+			t.Fatal(err)
+		}
+		apps, err := manifest.Applications()
+		if err != nil {
+			t.Errorf("%s: %s", data, err)
+			continue
+		}
+		if apps[0].Instances != expected {
+			t.Errorf("%s: got %d instances, expected %d", data, apps[0].Instances, expected)
+		}
+	}
+
+	for _, data := range []string{"instances: lots", "instances: -1", "instances: 1.5"} {
+		manifest := Manifest{}
+		yaml.Unmarshal([]byte(data), &manifest.data)
+		if _, err := manifest.Applications(); err == nil {
+			t.Errorf("%s: expected an error", data)
+		}
+	}
+}
+
+func TestForExistingApp(t *testing.T) {
+	manifest := Manifest{}
+	yaml.Unmarshal([]byte("applications:\n- name: web\n  memory: 64M\n"), &manifest.data)
+	renamed, err := manifest.ForExistingApp("my-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, _ := renamed.Applications()
+	if len(apps) != 1 || apps[0].Name != "my-web" || apps[0].Memory != "64M" {
+		t.Errorf("expected web renamed to my-web, got %+v", apps)
+	}
+
+	yaml.Unmarshal([]byte("applications:\n- name: web\n- name: worker\n"), &manifest.data)
+	if _, err := manifest.ForExistingApp("worker"); err != nil {
+		t.Errorf("expected worker to be found, got %v", err)
+	}
+	if _, err := manifest.ForExistingApp("my-web"); err == nil {
+		t.Error("expected an error for an app the manifest doesn't include")
+	}
+}
+
+func TestApplicationsRoutes(t *testing.T) {
+	manifest := Manifest{}
+	yaml.Unmarshal([]byte("applications:\n- name: web\n  routes:\n  - route: web.example.com\n  - route: www.example.com/app\n- name: worker\n"), &manifest.data)
+	apps, err := manifest.Applications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps[0].Routes) != 2 || apps[0].Routes[1] != "www.example.com/app" || len(apps[1].Routes) != 0 {
+		t.Errorf("got %+v", apps)
+	}
+}
@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/github"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+)
+
+var (
+	DeploymentsStarted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "deploy_to_cf_deployments_started_total",
+		Help: "Deployments started.",
+	})
+	DeploymentsFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deploy_to_cf_deployments_finished_total",
+		Help: "Deployments finished, by result and the phase they ended in.",
+	}, []string{"result", "phase"})
+	DeploymentDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "deploy_to_cf_deployment_duration_seconds",
+		Help:    "Time from submitting the form to the end of the deployment.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 9),
+	}, []string{"result"})
+	DeploymentsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deploy_to_cf_deployments_active",
+		Help: "Deployments in progress, including queued ones.",
+	})
+	DeploymentsQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "deploy_to_cf_deployments_queued",
+		Help: "Deployments waiting for a worker.",
+	})
+	ServiceProvisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "deploy_to_cf_service_provisioning_seconds",
+		Help:    "Time spent waiting for services to be provisioned.",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 9),
+	}, []string{"service", "result"})
+	CLICommands = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "deploy_to_cf_cli_command_duration_seconds",
+		Help: "Duration of cf commands, which call the Cloud Controller.",
+	}, []string{"command", "result"})
+	APIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deploy_to_cf_api_requests_total",
+		Help: "Requests to GitHub, the Cloud Controller and UAA, by status code or \"error\".",
+	}, []string{"api", "code"})
+	APIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "deploy_to_cf_api_request_duration_seconds",
+		Help: "Latency of requests to GitHub, the Cloud Controller and UAA.",
+	}, []string{"api"})
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "deploy_to_cf_logins_total",
+		Help: "Successful logins, by foundation.",
+	}, []string{"foundation"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		DeploymentsStarted,
+		DeploymentsFinished,
+		DeploymentDuration,
+		DeploymentsActive,
+		DeploymentsQueued,
+		ServiceProvisioningDuration,
+		CLICommands,
+		APIRequests,
+		APIRequestDuration,
+		Logins,
+	)
+}
+
+// instrumentedTransport counts and times the requests made through it.
+type instrumentedTransport struct {
+	api  string
+	next http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	APIRequestDuration.WithLabelValues(t.api).Observe(time.Since(start).Seconds())
+	if err != nil {
+		APIRequests.WithLabelValues(t.api, "error").Inc()
+		return nil, err
+	}
+	APIRequests.WithLabelValues(t.api, strconv.Itoa(resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// InstrumentedClient returns an HTTP client whose requests are recorded
+// under api.
+func InstrumentedClient(api string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: instrumentedTransport{api: api, next: http.DefaultTransport},
+	}
+}
+
+func NewGitHubClient() *github.Client {
+	return github.NewClient(InstrumentedClient("github", 0))
+}
+
+// OauthContext makes oauth2 clients and token exchanges built from it
+// record their requests under api.
+func OauthContext(ctx context.Context, api string) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, InstrumentedClient(api, 0))
+}
+
+// FinishDeployment records a deployment that ended in phase.
+func FinishDeployment(start time.Time, phase string, succeeded bool) {
+	result := "failed"
+	if succeeded {
+		result = "succeeded"
+	}
+	DeploymentsFinished.WithLabelValues(result, phase).Inc()
+	DeploymentDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return "succeeded"
+}
@@ -0,0 +1,144 @@
+package helpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Policy holds the operator's guardrails for what a deployment may do. It's
+// read from POLICY_FILE and checked before anything is created.
+type Policy struct {
+	MaxMemory       string        `yaml:"max_memory"`
+	MaxInstances    int           `yaml:"max_instances"`
+	Services        []ServiceRule `yaml:"services"`
+	ForbiddenSpaces []string      `yaml:"forbidden_spaces"`
+	RequiredEnv     []string      `yaml:"required_env"`
+
+	maxMemoryMB int
+}
+
+// ServiceRule allows a service offering, limited to Plans if any are listed.
+type ServiceRule struct {
+	Service string   `yaml:"service"`
+	Plans   []string `yaml:"plans"`
+}
+
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	policy := &Policy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+	if policy.MaxMemory != "" {
+		policy.maxMemoryMB, err = ParseMemory(policy.MaxMemory)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// ParseMemory converts a manifest memory value like 512M or 1G to megabytes.
+func ParseMemory(value string) (int, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(value, "B")
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1024
+		value = strings.TrimSuffix(value, "G")
+	case strings.HasSuffix(value, "M"):
+		value = strings.TrimSuffix(value, "M")
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid memory %q", value)
+	}
+	return number * multiplier, nil
+}
+
+// SpaceAllowed reports whether deployments to the space are permitted.
+func (p *Policy) SpaceAllowed(orgName, spaceName string) bool {
+	if p == nil {
+		return true
+	}
+	for _, pattern := range p.ForbiddenSpaces {
+		if ok, _ := path.Match(pattern, spaceName); ok {
+			return false
+		}
+		if ok, _ := path.Match(pattern, orgName+"/"+spaceName); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate lists every way the deployment breaks the policy.
+func (p *Policy) Evaluate(app App, manifest Manifest, orgName, spaceName string) []string {
+	if p == nil {
+		return nil
+	}
+	violations := []string{}
+
+	if !p.SpaceAllowed(orgName, spaceName) {
+		violations = append(violations, fmt.Sprintf("Deploying to %s / %s is not permitted.", orgName, spaceName))
+	}
+
+	apps, err := manifest.Applications()
+	if err != nil {
+		violations = append(violations, err.Error())
+	}
+	for _, settings := range apps {
+		if p.maxMemoryMB > 0 {
+			memory, err := ParseMemory(settings.Memory)
+			if settings.Memory == "" || err != nil {
+				violations = append(violations, fmt.Sprintf("Application %s must declare its memory, at most %s.", settings.Name, p.MaxMemory))
+			} else if memory > p.maxMemoryMB {
+				violations = append(violations, fmt.Sprintf("Application %s asks for %s of memory; at most %s is allowed.", settings.Name, settings.Memory, p.MaxMemory))
+			}
+		}
+		if p.MaxInstances > 0 && settings.Instances > p.MaxInstances {
+			violations = append(violations, fmt.Sprintf("Application %s asks for %d instances; at most %d are allowed.", settings.Name, settings.Instances, p.MaxInstances))
+		}
+		for _, name := range p.RequiredEnv {
+			if settings.Env[name] == "" {
+				violations = append(violations, fmt.Sprintf("Application %s needs environment variable %s.", settings.Name, name))
+			}
+		}
+	}
+
+	if len(p.Services) > 0 {
+		for _, service := range app.Services {
+			if !p.serviceAllowed(service) {
+				violations = append(violations, fmt.Sprintf("Service %s with plan %s is not allowed.", service.Service, service.Plan))
+			}
+		}
+	}
+
+	return violations
+}
+
+func (p *Policy) serviceAllowed(service Service) bool {
+	for _, rule := range p.Services {
+		if rule.Service != service.Service {
+			continue
+		}
+		if len(rule.Plans) == 0 {
+			return true
+		}
+		for _, plan := range rule.Plans {
+			if plan == service.Plan {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package helpers
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRequiredEnvPerApplication(t *testing.T) {
+	policy := &Policy{RequiredEnv: []string{"OWNER"}}
+	cases := map[string]int{
+		"env: {OWNER: team}\napplications:\n- name: web\n":                           0,
+		"applications:\n- name: web\n  env: {OWNER: team}\n":                         0,
+		"applications:\n- name: web\n  env: {OWNER: team}\n- name: worker\n":         1,
+		"env: {OWNER: team}\napplications:\n- name: web\n  env: {OWNER: \"\"}\n":     1,
+		"applications:\n- name: web\n  env: {OTHER: x}\n- name: worker\n  env: {}\n": 2,
+	}
+	for data, expected := range cases {
+		manifest := Manifest{}
+		if err := yaml.Unmarshal([]byte(data), &manifest.data); err != nil {
+			t.Fatal(err)
+		}
+		if violations := policy.Evaluate(App{}, manifest, "org", "space"); len(violations) != expected {
+			t.Errorf("%q: got violations %v, expected %d", data, violations, expected)
+		}
+	}
+}
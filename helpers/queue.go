@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("Too many deployments are waiting. Please try again in a few minutes.")
+
+// DeployQueue runs deployments on a fixed number of workers, holding up to
+// a fixed number more until a worker is free.
+type DeployQueue struct {
+	jobs chan func()
+	wg   sync.WaitGroup
+	mu   sync.RWMutex
+	done bool
+}
+
+func NewDeployQueue(workers, size int) *DeployQueue {
+	q := &DeployQueue{jobs: make(chan func(), size)}
+	for idx := 0; idx < workers; idx++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for job := range q.jobs {
+				DeploymentsQueued.Dec()
+				job()
+			}
+		}()
+	}
+	return q
+}
+
+// Submit queues job without blocking, returning ErrQueueFull if there's
+// no room.
+func (q *DeployQueue) Submit(job func()) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.done {
+		return ErrQueueFull
+	}
+	select {
+	case q.jobs <- job:
+		DeploymentsQueued.Inc()
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Drain stops accepting jobs and waits for the queued and running ones to
+// finish, or for ctx to end.
+func (q *DeployQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.done {
+		q.done = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
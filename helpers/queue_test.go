@@ -0,0 +1,155 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// When the test binary is run as cf, it stands in for the CLI: it reads the
+// target and token from $CF_HOME as the real one does and makes the request.
+func TestMain(m *testing.M) {
+	if os.Getenv("FAKE_CF") == "1" {
+		os.Exit(fakeCF(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func fakeCF(args []string) int {
+	if len(args) < 2 || args[0] != "curl" {
+		fmt.Fprintf(os.Stderr, "unsupported command %v\n", args)
+		return 1
+	}
+	data, err := ioutil.ReadFile(filepath.Join(os.Getenv("CF_HOME"), ".cf", "config.json"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	config := struct {
+		Target      string
+		AccessToken string
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	req, _ := http.NewRequest("GET", config.Target+args[1], nil)
+	req.Header.Set("Authorization", config.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	os.Stdout.Write(body)
+	return 0
+}
+
+// useFakeCF puts the test binary first on $PATH as cf, returning a function
+// that undoes it.
+func useFakeCF(t *testing.T) func() {
+	bin, err := ioutil.TempDir("", "fake-cf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(executable, filepath.Join(bin, "cf")); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	os.Setenv("FAKE_CF", "1")
+	return func() {
+		os.Setenv("PATH", path)
+		os.Unsetenv("FAKE_CF")
+		os.RemoveAll(bin)
+	}
+}
+
+// TestParallelDeploysAreIsolated runs deployments for different users and
+// spaces on the queue at once, and checks that the Cloud Controller only
+// ever sees each user's token with that user's space.
+func TestParallelDeploysAreIsolated(t *testing.T) {
+	mu := sync.Mutex{}
+	seen := map[string]map[string]bool{}
+	cc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold requests briefly so that the deployments overlap.
+		time.Sleep(20 * time.Millisecond)
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "bearer ")
+		space := r.URL.Query().Get("space_guids")
+		mu.Lock()
+		if seen[token] == nil {
+			seen[token] = map[string]bool{}
+		}
+		seen[token][space] = true
+		mu.Unlock()
+		fmt.Fprintf(w, `{"resources": [{"guid": "app-in-%s"}]}`, space)
+	}))
+	defer cc.Close()
+
+	defer useFakeCF(t)()
+
+	const deployments = 8
+	queue := NewDeployQueue(4, deployments)
+	foundation := Foundation{Name: "test", CFURL: cc.URL}
+	errs := make(chan error, deployments)
+	for idx := 0; idx < deployments; idx++ {
+		home, err := ioutil.TempDir("", "cf-home")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(home)
+
+		token := oauth2.Token{TokenType: "bearer", AccessToken: fmt.Sprintf("token-%d", idx)}
+		space := fmt.Sprintf("space-%d", idx)
+		cf := NewCloudFoundry(foundation, token, home, "org", "org", space, space)
+		err = queue.Submit(func() {
+			if err := cf.WriteConfig(); err != nil {
+				errs <- err
+				return
+			}
+			for attempt := 0; attempt < 3; attempt++ {
+				guid, err := cf.appGUID("app")
+				if err != nil {
+					errs <- err
+					return
+				}
+				if guid != "app-in-"+space {
+					errs <- fmt.Errorf("%s got the app in %s", space, guid)
+					return
+				}
+			}
+			errs <- nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for idx := 0; idx < deployments; idx++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+
+	for idx := 0; idx < deployments; idx++ {
+		token, space := fmt.Sprintf("token-%d", idx), fmt.Sprintf("space-%d", idx)
+		if len(seen[token]) != 1 || !seen[token][space] {
+			t.Errorf("%s was used for spaces %v", token, seen[token])
+		}
+	}
+}
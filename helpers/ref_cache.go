@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// RefCache remembers which commit a ref pointed at for a short time, so
+// that listing many apps from the same repository doesn't ask GitHub about
+// each one on every page load.
+type RefCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]refCacheEntry
+}
+
+type refCacheEntry struct {
+	sha     string
+	expires time.Time
+}
+
+func NewRefCache(ttl time.Duration) *RefCache {
+	return &RefCache{
+		ttl:     ttl,
+		entries: map[string]refCacheEntry{},
+	}
+}
+
+func RefCacheKey(owner, repo, ref string) string {
+	return owner + "/" + repo + "@" + ref
+}
+
+func (c *RefCache) Get(key string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return entry.sha, true
+}
+
+func (c *RefCache) Set(key, sha string) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = refCacheEntry{sha: sha, expires: now.Add(c.ttl)}
+}
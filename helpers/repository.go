@@ -0,0 +1,102 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	log "github.com/sirupsen/logrus"
+)
+
+// RepoInfo describes a repository at a commit for the deploy page.
+type RepoInfo struct {
+	Description   string
+	URL           string
+	CommitDate    time.Time
+	CommitMessage string
+	Readme        template.HTML
+}
+
+// FetchRepoInfo caches what it fetches by commit, since none of it changes
+// for a given sha; every page view would otherwise make four GitHub calls.
+func FetchRepoInfo(client *github.Client, cache Cache, owner, repo, sha string) (RepoInfo, error) {
+	key := CacheKey("github", owner, repo, sha, "info.json")
+	if cache != nil {
+		if reader, err := cache.Get(key); err == nil {
+			defer reader.Close()
+			info := RepoInfo{}
+			if err := json.NewDecoder(reader).Decode(&info); err == nil {
+				return info, nil
+			}
+		}
+	}
+
+	info, err := fetchRepoInfo(client, owner, repo, sha)
+	if err != nil {
+		return info, err
+	}
+	if cache != nil {
+		data, err := json.Marshal(info)
+		if err == nil {
+			err = cache.Put(key, bytes.NewReader(data))
+		}
+		if err != nil {
+			log.WithError(err).Warn("Error caching repository details")
+		}
+	}
+	return info, nil
+}
+
+func fetchRepoInfo(client *github.Client, owner, repo, sha string) (RepoInfo, error) {
+	ctx := context.Background()
+	info := RepoInfo{}
+
+	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return info, err
+	}
+	info.Description = repository.GetDescription()
+	info.URL = repository.GetHTMLURL()
+
+	commit, _, err := client.Repositories.GetCommit(ctx, owner, repo, sha)
+	if err != nil {
+		return info, err
+	}
+	info.CommitDate = commit.GetCommit().GetCommitter().GetDate()
+	info.CommitMessage = strings.SplitN(commit.GetCommit().GetMessage(), "\n", 2)[0]
+
+	readme, err := fetchReadme(client, owner, repo, sha)
+	if err != nil {
+		return info, err
+	}
+	info.Readme = readme
+	return info, nil
+}
+
+// fetchReadme renders the README through GitHub's Markdown API, which also
+// sanitizes the HTML. Repositories without a README get an empty one.
+func fetchReadme(client *github.Client, owner, repo, sha string) (template.HTML, error) {
+	ctx := context.Background()
+	content, resp, err := client.Repositories.GetReadme(ctx, owner, repo, &github.RepositoryContentGetOptions{Ref: sha})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	text, err := content.GetContent()
+	if err != nil {
+		return "", err
+	}
+
+	html, _, err := client.Markdown(ctx, text, &github.MarkdownOptions{Mode: "gfm", Context: owner + "/" + repo})
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(html), nil
+}
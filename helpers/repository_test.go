@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+func TestFetchRepoInfoIsCached(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/repos/o/r":
+			fmt.Fprint(w, `{"description": "An app", "html_url": "https://github.com/o/r"}`)
+		case "/repos/o/r/commits/abc":
+			fmt.Fprint(w, `{"commit": {"message": "Fix it\n\nDetails"}}`)
+		case "/repos/o/r/readme":
+			fmt.Fprint(w, `{"encoding": "base64", "content": "IyBBcHA="}`)
+		case "/markdown":
+			fmt.Fprint(w, "<h1>App</h1>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	cache := memoryCache{}
+	for attempt := 0; attempt < 2; attempt++ {
+		info, err := FetchRepoInfo(client, cache, "o", "r", "abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Description != "An app" || info.CommitMessage != "Fix it" || info.Readme != "<h1>App</h1>" {
+			t.Errorf("got %+v", info)
+		}
+	}
+	if requests != 4 {
+		t.Errorf("expected 4 requests to GitHub, got %d", requests)
+	}
+}
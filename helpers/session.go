@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"golang.org/x/oauth2"
+)
+
+// Login is what the session remembers about a user on one foundation.
+type Login struct {
+	Token   oauth2.Token
+	User    User
+	Expires int64
+}
+
+func (l Login) Valid() bool {
+	return l.Token.AccessToken != "" && time.Now().Unix() < l.Expires
+}
+
+func GetLogins(session *sessions.Session) map[string]Login {
+	logins, ok := session.Values["logins"].(map[string]Login)
+	if !ok {
+		logins = map[string]Login{}
+		session.Values["logins"] = logins
+	}
+	return logins
+}
+
+func (c *Context) Foundation(name string) (Foundation, bool) {
+	for _, foundation := range c.Foundations {
+		if foundation.Name == name {
+			return foundation, true
+		}
+	}
+	return Foundation{}, false
+}
+
+// SelectedFoundation returns the foundation chosen in the session; with a
+// single foundation configured there is nothing to choose.
+func (c *Context) SelectedFoundation(session *sessions.Session) (Foundation, bool) {
+	if len(c.Foundations) == 1 {
+		return c.Foundations[0], true
+	}
+	name, _ := session.Values["foundation"].(string)
+	return c.Foundation(name)
+}
+
+func (c *Context) CurrentLogin(r *http.Request) (Foundation, Login, error) {
+	session, _ := c.Store.Get(r, "session")
+	foundation, ok := c.SelectedFoundation(session)
+	if !ok {
+		return Foundation{}, Login{}, errors.New("No foundation selected")
+	}
+	login, ok := GetLogins(session)[foundation.Name]
+	if !ok || !login.Valid() {
+		return Foundation{}, Login{}, errors.New("Not logged in")
+	}
+	return foundation, login, nil
+}
@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boj/redistore"
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/sessions"
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewStore builds the session store selected by SESSION_STORE. Only the
+// filesystem store is local to an instance; the others can be shared. The
+// server-side stores take sessions of any size, since logins to several
+// foundations and the deploy history quickly pass securecookie's default.
+func NewStore(config Config) (sessions.Store, error) {
+	keyPairs := [][]byte{[]byte(config.SecretKey)}
+	if config.SessionEncryptionKey != "" {
+		keyPairs = append(keyPairs, []byte(config.SessionEncryptionKey))
+	}
+
+	switch config.SessionStore {
+	case "filesystem":
+		store := sessions.NewFilesystemStore(os.TempDir(), keyPairs...)
+		store.MaxLength(0)
+		store.MaxAge(config.SessionTimeout)
+		return store, nil
+	case "cookie":
+		store := NewCookieStore(keyPairs...)
+		store.MaxAge(config.SessionTimeout)
+		return store, nil
+	case "redis":
+		store, err := redistore.NewRediStoreWithPool(NewRedisPool(config.SessionStoreURL), keyPairs...)
+		if err != nil {
+			return nil, err
+		}
+		store.SetMaxLength(0)
+		store.SetMaxAge(config.SessionTimeout)
+		return store, nil
+	case "sql":
+		db, err := sql.Open("postgres", config.SessionStoreURL)
+		if err != nil {
+			return nil, err
+		}
+		store, err := NewSQLStore(db, keyPairs...)
+		if err != nil {
+			return nil, err
+		}
+		store.MaxAge(config.SessionTimeout)
+		go func() {
+			for range time.Tick(time.Hour) {
+				if err := store.Cleanup(); err != nil {
+					log.WithError(err).Warn("Error removing expired sessions")
+				}
+			}
+		}()
+		return store, nil
+	}
+	return nil, fmt.Errorf("Unknown session store %q", config.SessionStore)
+}
+
+func NewRedisPool(url string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle: 10,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(url)
+		},
+	}
+}
@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFilesystemStoreTakesLargeSessions(t *testing.T) {
+	store, err := NewStore(Config{SessionStore: "filesystem", SecretKey: "secret", SessionTimeout: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	session, _ := store.New(r, "session")
+	session.Values["logins"] = strings.Repeat("token.", 4000)
+	w := httptest.NewRecorder()
+	if err := store.Save(r, w, session); err != nil {
+		t.Fatal(err)
+	}
+
+	// Expiring the session removes its file.
+	session.Options.MaxAge = -1
+	store.Save(r, httptest.NewRecorder(), session)
+}
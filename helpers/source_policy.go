@@ -0,0 +1,104 @@
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// SourcePolicy restricts which repositories, refs and artifacts may be
+// deployed. Patterns are globs such as "18f/*" or "v*".
+type SourcePolicy struct {
+	Allowed       []string
+	Denied        []string
+	Refs          []string
+	RequireTags   bool
+	ArtifactHosts []string
+}
+
+// PolicyError explains why a deployment was refused.
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Reason
+}
+
+func (c Config) SourcePolicy() SourcePolicy {
+	return SourcePolicy{
+		Allowed:       c.AllowedSources,
+		Denied:        c.DeniedSources,
+		Refs:          c.AllowedRefs,
+		RequireTags:   c.RequireTags,
+		ArtifactHosts: c.AllowedArtifactHosts,
+	}
+}
+
+// Check returns a PolicyError if the source may not be deployed. When refs
+// are restricted, a pinned sha must still be what ref points at; otherwise
+// any commit could be deployed under the name of an allowed ref. Likewise, a
+// release asset must come from the release of ref itself.
+func (p SourcePolicy) Check(client *github.Client, owner, repo, ref, sha, release, artifact string) error {
+	name := strings.ToLower(owner + "/" + repo)
+	if matchAny(p.Denied, name) {
+		return &PolicyError{fmt.Sprintf("Deploying %s/%s is not permitted here.", owner, repo)}
+	}
+	if len(p.Allowed) > 0 && !matchAny(p.Allowed, name) {
+		return &PolicyError{fmt.Sprintf("%s/%s is not on the list of repositories that may be deployed here.", owner, repo)}
+	}
+	if len(p.Refs) > 0 && !matchAny(p.Refs, ref) {
+		return &PolicyError{fmt.Sprintf("Ref %s may not be deployed; allowed refs are %s.", ref, strings.Join(p.Refs, ", "))}
+	}
+	if release != "" && release != ref && (len(p.Refs) > 0 || p.RequireTags) {
+		return &PolicyError{fmt.Sprintf("Release assets must come from release %s, the ref being deployed.", ref)}
+	}
+
+	if artifact != "" {
+		// Once sources are restricted, an arbitrary URL would be a way around it.
+		if len(p.ArtifactHosts) == 0 && len(p.Allowed) > 0 {
+			return &PolicyError{"Deploying from artifact URLs is not permitted here."}
+		}
+		if len(p.ArtifactHosts) > 0 {
+			parsed, err := url.Parse(artifact)
+			if err != nil || !matchAny(p.ArtifactHosts, strings.ToLower(parsed.Host)) {
+				return &PolicyError{"Artifacts may not be downloaded from that host."}
+			}
+		}
+	}
+
+	if sha != "" && (len(p.Refs) > 0 || p.RequireTags) {
+		current, _, err := client.Repositories.GetCommitSHA1(context.Background(), owner, repo, ref, "")
+		if err != nil {
+			return err
+		}
+		if current != sha {
+			return &PolicyError{fmt.Sprintf("%s no longer points at commit %s. Reload the page and try again.", ref, sha)}
+		}
+	}
+
+	if p.RequireTags {
+		_, resp, err := client.Git.GetRef(context.Background(), owner, repo, "tags/"+ref)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return &PolicyError{fmt.Sprintf("Only tags may be deployed, and %s is not a tag.", ref)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), value); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package helpers
+
+import "testing"
+
+func TestSourcePolicyReleaseMustMatchRef(t *testing.T) {
+	policy := SourcePolicy{Refs: []string{"v*"}}
+	// No sha, so no lookups on GitHub are needed.
+	if err := policy.Check(nil, "o", "r", "v1", "", "v1", ""); err != nil {
+		t.Errorf("expected the ref's own release to be allowed, got %v", err)
+	}
+	if _, ok := policy.Check(nil, "o", "r", "v1", "", "other-tag", "").(*PolicyError); !ok {
+		t.Error("expected another release to be rejected")
+	}
+	if _, ok := policy.Check(nil, "o", "r", "master", "", "", "").(*PolicyError); !ok {
+		t.Error("expected a ref outside ALLOWED_REFS to be rejected")
+	}
+}
@@ -0,0 +1,135 @@
+package helpers
+
+import (
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// SQLStore keeps session values in a PostgreSQL table and only the signed
+// session ID in the cookie.
+type SQLStore struct {
+	db      *sql.DB
+	Codecs  []securecookie.Codec
+	Options *sessions.Options
+}
+
+func NewSQLStore(db *sql.DB, keyPairs ...[]byte) (*SQLStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		id TEXT PRIMARY KEY,
+		data TEXT NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &SQLStore{
+		db:     db,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+	}
+	store.MaxAge(store.Options.MaxAge)
+	return store, nil
+}
+
+func (s *SQLStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+			sc.MaxLength(0)
+		}
+	}
+}
+
+func (s *SQLStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *SQLStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	err = securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+
+	var data string
+	err = s.db.QueryRow(
+		"SELECT data FROM sessions WHERE id = $1 AND expires_at > $2",
+		session.ID, time.Now(),
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+
+	err = securecookie.DecodeMulti(name, data, &session.Values, s.Codecs...)
+	if err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+func (s *SQLStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if _, err := s.db.Exec("DELETE FROM sessions WHERE id = $1", session.ID); err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	expires := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+	_, err = s.db.Exec(
+		`INSERT INTO sessions (id, data, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO UPDATE SET data = $2, expires_at = $3`,
+		session.ID, data, expires,
+	)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Cleanup deletes expired sessions; the store never reads them, but they
+// would otherwise accumulate.
+func (s *SQLStore) Cleanup() error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= $1", time.Now())
+	return err
+}
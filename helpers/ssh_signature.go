@@ -0,0 +1,103 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSigMagic starts both an SSH signature and the blob it signs, in the
+// format written by ssh-keygen -Y sign and used by git.
+const sshSigMagic = "SSHSIG"
+
+// ParseAllowedSigners reads the public keys from a file in the format of
+// git's gpg.ssh.allowedSignersFile: a principal list, optional options and
+// a key on each line. Principals aren't checked; any listed key is trusted.
+func ParseAllowedSigners(reader io.Reader) ([]ssh.PublicKey, error) {
+	keys := []ssh.PublicKey{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		// What follows the principals is an authorized_keys line.
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(text[len(fields[0]):])))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid allowed signer on line %d: %s", line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// verifySSHSignature checks an armored SSH signature of payload made for
+// git with one of keys.
+func verifySSHSignature(keys []ssh.PublicKey, payload, armored string) error {
+	block, _ := pem.Decode([]byte(armored))
+	if block == nil || block.Type != "SSH SIGNATURE" || !bytes.HasPrefix(block.Bytes, []byte(sshSigMagic)) {
+		return errors.New("its SSH signature can't be read")
+	}
+	sig := struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{}
+	if err := ssh.Unmarshal(block.Bytes[len(sshSigMagic):], &sig); err != nil || sig.Version != 1 {
+		return errors.New("its SSH signature can't be read")
+	}
+	if sig.Namespace != "git" {
+		return errors.New("its SSH signature wasn't made for git")
+	}
+
+	key, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return errors.New("its SSH signature can't be read")
+	}
+	trusted := false
+	for _, allowed := range keys {
+		trusted = trusted || bytes.Equal(allowed.Marshal(), key.Marshal())
+	}
+	if !trusted {
+		return errors.New("it is not signed by a trusted key")
+	}
+
+	var digest hash.Hash
+	switch sig.HashAlgorithm {
+	case "sha256":
+		digest = sha256.New()
+	case "sha512":
+		digest = sha512.New()
+	default:
+		return fmt.Errorf("its SSH signature uses unsupported hash %s", sig.HashAlgorithm)
+	}
+	digest.Write([]byte(payload))
+	signed := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlgorithm, digest.Sum(nil)})...)
+
+	signature := ssh.Signature{}
+	if err := ssh.Unmarshal(sig.Signature, &signature); err != nil {
+		return errors.New("its SSH signature can't be read")
+	}
+	if err := key.Verify(signed, &signature); err != nil {
+		return errors.New("its SSH signature doesn't match")
+	}
+	return nil
+}
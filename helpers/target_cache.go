@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// TargetCache remembers each user's spaces for a short time so that page
+// loads don't have to walk every org and space page again.
+type TargetCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]targetCacheEntry
+}
+
+type targetCacheEntry struct {
+	spaces  []Space
+	expires time.Time
+}
+
+func NewTargetCache(ttl time.Duration) *TargetCache {
+	return &TargetCache{
+		ttl:     ttl,
+		entries: map[string]targetCacheEntry{},
+	}
+}
+
+func TargetCacheKey(foundation Foundation, userID string) string {
+	return foundation.Name + ":" + userID
+}
+
+func (c *TargetCache) Get(key string) ([]Space, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return append([]Space{}, entry.spaces...), true
+}
+
+func (c *TargetCache) Set(key string, spaces []Space) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = targetCacheEntry{
+		spaces:  append([]Space{}, spaces...),
+		expires: now.Add(c.ttl),
+	}
+}
+
+func (c *TargetCache) Invalidate(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.entries, key)
+}
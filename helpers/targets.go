@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+const maxRecentSpaces = 10
+
+type TargetGroup struct {
+	OrgGUID string  `json:"org_guid"`
+	OrgName string  `json:"org_name"`
+	Spaces  []Space `json:"spaces"`
+}
+
+// FilterTargets keeps the spaces matching the org and space names; empty
+// names match everything.
+func FilterTargets(spaces []Space, org, space string) []Space {
+	filtered := []Space{}
+	for _, s := range spaces {
+		if org != "" && !strings.EqualFold(s.Entity.OrgName, org) {
+			continue
+		}
+		if space != "" && !strings.EqualFold(s.Entity.Name, space) {
+			continue
+		}
+		filtered = append(filtered, s)
+	}
+	return filtered
+}
+
+// GroupTargets groups spaces by org. Recently used spaces come first, both
+// within their org and when ordering the orgs; the rest are sorted by name.
+func GroupTargets(spaces []Space, recent []string) []TargetGroup {
+	rank := map[string]int{}
+	for idx, guid := range recent {
+		rank[guid] = idx + 1
+	}
+	less := func(a, b string) bool {
+		ra, rb := rank[a], rank[b]
+		if ra == 0 || rb == 0 {
+			return ra > rb
+		}
+		return ra < rb
+	}
+
+	sorted := append([]Space{}, spaces...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if rank[a.Meta.GUID] != rank[b.Meta.GUID] {
+			return less(a.Meta.GUID, b.Meta.GUID)
+		}
+		return strings.ToLower(a.Entity.Name) < strings.ToLower(b.Entity.Name)
+	})
+
+	groups := []TargetGroup{}
+	index := map[string]int{}
+	for _, space := range sorted {
+		idx, ok := index[space.Entity.OrgGUID]
+		if !ok {
+			idx = len(groups)
+			index[space.Entity.OrgGUID] = idx
+			groups = append(groups, TargetGroup{OrgGUID: space.Entity.OrgGUID, OrgName: space.Entity.OrgName})
+		}
+		groups[idx].Spaces = append(groups[idx].Spaces, space)
+	}
+
+	// Groups holding a recent space are already in recency order; sort the rest by name.
+	sort.SliceStable(groups, func(i, j int) bool {
+		ri, rj := rank[groups[i].Spaces[0].Meta.GUID], rank[groups[j].Spaces[0].Meta.GUID]
+		if ri != 0 || rj != 0 {
+			return less(groups[i].Spaces[0].Meta.GUID, groups[j].Spaces[0].Meta.GUID)
+		}
+		return strings.ToLower(groups[i].OrgName) < strings.ToLower(groups[j].OrgName)
+	})
+	return groups
+}
+
+func RecentSpaces(session *sessions.Session) []string {
+	recent, _ := session.Values["recent_spaces"].([]string)
+	return recent
+}
+
+func AddRecentSpace(session *sessions.Session, guid string) {
+	recent := []string{guid}
+	for _, g := range RecentSpaces(session) {
+		if g != guid && len(recent) < maxRecentSpaces {
+			recent = append(recent, g)
+		}
+	}
+	session.Values["recent_spaces"] = recent
+}
@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+type User struct {
+	ID       string `json:"user_id"`
+	UserName string `json:"user_name"`
+	Email    string `json:"email"`
+}
+
+// ParseIDToken reads the user claims from an OpenID id_token. The token comes
+// straight from the UAA token endpoint over TLS, so the signature isn't checked.
+func ParseIDToken(token string) (User, error) {
+	return parseClaims(token)
+}
+
+// ParseAccessToken reads the user claims from a UAA access token. Unlike the
+// id_token, it always carries user_id.
+func ParseAccessToken(token string) (User, error) {
+	return parseClaims(token)
+}
+
+func parseClaims(token string) (User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return User{}, errors.New("Malformed token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{}
+	if err := json.Unmarshal(payload, &user); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,20 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseAccessToken(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"user_id": "user-1", "user_name": "dev", "scope": ["cloud_controller.read"]}`))
+	user, err := ParseAccessToken("header." + claims + ".signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "user-1" || user.UserName != "dev" {
+		t.Errorf("got %+v", user)
+	}
+	if _, err := ParseAccessToken("opaque"); err == nil {
+		t.Error("expected an error for a token that isn't a JWT")
+	}
+}
@@ -0,0 +1,235 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	VerifySignatures = "signature"
+	VerifyChecksums  = "checksum"
+)
+
+// Verifier checks that a resolved source was published by someone trusted:
+// either the commit or its tag is signed, or the release that the ref names
+// lists a checksum for what is being deployed.
+type Verifier struct {
+	Mode           string
+	Keyring        openpgp.EntityList
+	SSHKeys        []ssh.PublicKey
+	ChecksumsAsset string
+}
+
+// NewVerifier returns nil when verification is turned off. Signature mode
+// needs trusted GPG keys, SSH keys or both: GitHub marks a signature
+// verified whenever it matches a key of the GitHub user who made it.
+func NewVerifier(config Config) (*Verifier, error) {
+	switch config.VerifySources {
+	case "":
+		return nil, nil
+	case VerifySignatures, VerifyChecksums:
+	default:
+		return nil, fmt.Errorf("Unknown source verification %q", config.VerifySources)
+	}
+
+	verifier := &Verifier{
+		Mode:           config.VerifySources,
+		ChecksumsAsset: config.ChecksumsAsset,
+	}
+	if config.TrustedKeysFile != "" {
+		file, err := os.Open(config.TrustedKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		verifier.Keyring, err = openpgp.ReadArmoredKeyRing(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.AllowedSignersFile != "" {
+		file, err := os.Open(config.AllowedSignersFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		verifier.SSHKeys, err = ParseAllowedSigners(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if verifier.Mode == VerifySignatures && verifier.Keyring == nil && len(verifier.SSHKeys) == 0 {
+		return nil, fmt.Errorf("VERIFY_SOURCES=%s needs TRUSTED_KEYS_FILE or ALLOWED_SIGNERS_FILE", VerifySignatures)
+	}
+	return verifier, nil
+}
+
+// VerifySignature returns a PolicyError unless ref is an annotated tag
+// pointing at sha with a trusted signature, or the commit itself has one.
+func (v *Verifier) VerifySignature(client *github.Client, owner, repo, ref, sha string) error {
+	reference, resp, err := client.Git.GetRef(context.Background(), owner, repo, "tags/"+ref)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return err
+	}
+	if err == nil && reference.GetObject().GetType() == "tag" {
+		tag, _, err := client.Git.GetTag(context.Background(), owner, repo, reference.GetObject().GetSHA())
+		if err != nil {
+			return err
+		}
+		if tag.GetObject().GetSHA() == sha && v.trusted(tag.Verification) == nil {
+			return nil
+		}
+	}
+
+	commit, _, err := client.Git.GetCommit(context.Background(), owner, repo, sha)
+	if err != nil {
+		return err
+	}
+	if err := v.trusted(commit.Verification); err != nil {
+		return &PolicyError{fmt.Sprintf("Commit %s of %s/%s can't be deployed: %s.", sha, owner, repo, err)}
+	}
+	return nil
+}
+
+func (v *Verifier) trusted(verification *github.SignatureVerification) error {
+	if verification == nil || verification.GetSignature() == "" {
+		return fmt.Errorf("it is not signed")
+	}
+	if strings.HasPrefix(verification.GetSignature(), "-----BEGIN SSH SIGNATURE-----") {
+		return verifySSHSignature(v.SSHKeys, verification.GetPayload(), verification.GetSignature())
+	}
+	if !strings.HasPrefix(verification.GetSignature(), "-----BEGIN PGP SIGNATURE-----") {
+		return fmt.Errorf("only GPG and SSH signatures can be checked against the trusted keys")
+	}
+	if v.Keyring == nil {
+		return fmt.Errorf("it has a GPG signature, and no GPG keys are trusted")
+	}
+	_, err := openpgp.CheckArmoredDetachedSignature(
+		v.Keyring,
+		strings.NewReader(verification.GetPayload()),
+		strings.NewReader(verification.GetSignature()),
+	)
+	if err != nil {
+		return fmt.Errorf("it is not signed by a trusted key")
+	}
+	return nil
+}
+
+// maxReleaseFileSize bounds the checksums and signature files read from a
+// release.
+const maxReleaseFileSize = 1 << 20
+
+// PublishedChecksum looks up name in the checksums file attached to the
+// release tagged ref, in the format written by sha256sum.
+func (v *Verifier) PublishedChecksum(client *github.Client, owner, repo, ref, name string) (string, error) {
+	checksums, err := releaseFile(client, owner, repo, ref, v.ChecksumsAsset)
+	if err != nil {
+		return "", err
+	}
+	if checksums == nil {
+		return "", &PolicyError{fmt.Sprintf("Release %s of %s/%s has no %s file.", ref, owner, repo, v.ChecksumsAsset)}
+	}
+	return releaseChecksum(checksums, owner, repo, ref, name)
+}
+
+// SignedChecksum is PublishedChecksum for a checksums file that must come
+// with a detached signature by a trusted key, as <file>.asc or <file>.sig.
+// A signed tag covers the source, but not the assets uploaded to its
+// release.
+func (v *Verifier) SignedChecksum(client *github.Client, owner, repo, ref, name string) (string, error) {
+	if v.Keyring == nil {
+		return "", &PolicyError{"Release assets can only be verified with trusted GPG keys; deploy the source instead."}
+	}
+	checksums, err := releaseFile(client, owner, repo, ref, v.ChecksumsAsset)
+	if err != nil {
+		return "", err
+	}
+	if checksums == nil {
+		return "", &PolicyError{fmt.Sprintf("Release %s of %s/%s has no %s file.", ref, owner, repo, v.ChecksumsAsset)}
+	}
+
+	signed := false
+	for _, suffix := range []string{".asc", ".sig"} {
+		signature, err := releaseFile(client, owner, repo, ref, v.ChecksumsAsset+suffix)
+		if err != nil {
+			return "", err
+		}
+		if signature == nil {
+			continue
+		}
+		if suffix == ".asc" {
+			_, err = openpgp.CheckArmoredDetachedSignature(v.Keyring, bytes.NewReader(checksums), bytes.NewReader(signature))
+		} else {
+			_, err = openpgp.CheckDetachedSignature(v.Keyring, bytes.NewReader(checksums), bytes.NewReader(signature))
+		}
+		if err != nil {
+			return "", &PolicyError{fmt.Sprintf("The %s file of release %s of %s/%s is not signed by a trusted key.", v.ChecksumsAsset, ref, owner, repo)}
+		}
+		signed = true
+		break
+	}
+	if !signed {
+		return "", &PolicyError{fmt.Sprintf("Release %s of %s/%s has no signature for its %s file.", ref, owner, repo, v.ChecksumsAsset)}
+	}
+	return releaseChecksum(checksums, owner, repo, ref, name)
+}
+
+func releaseChecksum(checksums []byte, owner, repo, ref, name string) (string, error) {
+	checksum, err := findChecksum(bytes.NewReader(checksums), name)
+	if err != nil {
+		return "", err
+	}
+	if checksum == "" {
+		return "", &PolicyError{fmt.Sprintf("Release %s of %s/%s publishes no checksum for %s.", ref, owner, repo, name)}
+	}
+	return "sha256:" + checksum, nil
+}
+
+// releaseFile reads a small file attached to the release tagged ref,
+// returning nil if the release has no such file.
+func releaseFile(client *github.Client, owner, repo, ref, name string) ([]byte, error) {
+	asset, err := findReleaseAsset(client, owner, repo, ref, name)
+	if ghErr, ok := err.(*github.ErrorResponse); ok && ghErr.Response.StatusCode == http.StatusNotFound {
+		return nil, &PolicyError{fmt.Sprintf("%s is not a release of %s/%s, so it has no published checksums.", ref, owner, repo)}
+	}
+	if err != nil || asset == nil {
+		return nil, err
+	}
+
+	reader, err := openReleaseAsset(client, owner, repo, asset)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(io.LimitReader(reader, maxReleaseFileSize))
+}
+
+func findChecksum(reader io.Reader, name string) (string, error) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// TarballName is the name of the source tarball that, in checksum mode, must
+// be attached to the release tagged ref and listed in its checksums file.
+// GitHub generates the tarballs on its release page on the fly, so their
+// bytes can't be relied on to match a published checksum.
+func TarballName(repo, ref string) string {
+	return fmt.Sprintf("%s-%s.tar.gz", repo, strings.TrimPrefix(ref, "v"))
+}
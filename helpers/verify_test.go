@@ -0,0 +1,153 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeRelease serves release v1 of o/r on a fake GitHub with the given assets.
+func fakeRelease(t *testing.T, assets map[string][]byte) (*github.Client, func()) {
+	names := []string{}
+	for name := range assets {
+		names = append(names, name)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/o/r/releases/tags/v1", func(w http.ResponseWriter, r *http.Request) {
+		list := []map[string]interface{}{}
+		for idx, name := range names {
+			list = append(list, map[string]interface{}{"id": idx, "name": name})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"tag_name": "v1", "assets": list})
+	})
+	for idx, name := range names {
+		data := assets[name]
+		mux.HandleFunc(fmt.Sprintf("/repos/o/r/releases/assets/%d", idx), func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		})
+	}
+	server := httptest.NewServer(mux)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client, server.Close
+}
+
+func TestSignedChecksum(t *testing.T) {
+	trusted, err := openpgp.NewEntity("trusted", "", "trusted@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksums := []byte("abc123  app.tar.gz\n")
+	sign := func(entity *openpgp.Entity) []byte {
+		signature := bytes.Buffer{}
+		if err := openpgp.ArmoredDetachSign(&signature, entity, bytes.NewReader(checksums), nil); err != nil {
+			t.Fatal(err)
+		}
+		return signature.Bytes()
+	}
+	verifier := &Verifier{Mode: VerifySignatures, Keyring: openpgp.EntityList{trusted}, ChecksumsAsset: "SHA256SUMS"}
+
+	client, done := fakeRelease(t, map[string][]byte{"SHA256SUMS": checksums, "SHA256SUMS.asc": sign(trusted)})
+	checksum, err := verifier.SignedChecksum(client, "o", "r", "v1", "app.tar.gz")
+	done()
+	if err != nil || checksum != "sha256:abc123" {
+		t.Errorf("got %q, %v; expected sha256:abc123", checksum, err)
+	}
+
+	cases := map[string]map[string][]byte{
+		"unsigned":      {"SHA256SUMS": checksums},
+		"untrusted key": {"SHA256SUMS": checksums, "SHA256SUMS.asc": sign(other)},
+		"tampered":      {"SHA256SUMS": []byte("def456  app.tar.gz\n"), "SHA256SUMS.asc": sign(trusted)},
+	}
+	for name, assets := range cases {
+		client, done := fakeRelease(t, assets)
+		_, err := verifier.SignedChecksum(client, "o", "r", "v1", "app.tar.gz")
+		done()
+		if _, ok := err.(*PolicyError); !ok {
+			t.Errorf("%s: expected a PolicyError, got %v", name, err)
+		}
+	}
+}
+
+// sshSign signs payload for git the way ssh-keygen -Y sign does.
+func sshSign(t *testing.T, signer ssh.Signer, payload string) string {
+	digest := sha512.Sum512([]byte(payload))
+	signed := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Namespace, Reserved, HashAlgorithm string
+		Hash                               []byte
+	}{"git", "", "sha512", digest[:]})...)
+	signature, err := signer.Sign(rand.Reader, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := append([]byte(sshSigMagic), ssh.Marshal(struct {
+		Version                            uint32
+		PublicKey                          []byte
+		Namespace, Reserved, HashAlgorithm string
+		Signature                          []byte
+	}{1, signer.PublicKey().Marshal(), "git", "", "sha512", ssh.Marshal(signature)})...)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob}))
+}
+
+func TestSSHSignatures(t *testing.T) {
+	signers := []ssh.Signer{}
+	for idx := 0; idx < 2; idx++ {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers = append(signers, signer)
+	}
+	allowed := "# reviewers\ndev@example.com namespaces=\"git\" " + string(ssh.MarshalAuthorizedKey(signers[0].PublicKey()))
+	keys, err := ParseAllowedSigners(strings.NewReader(allowed))
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("got %d keys, %v", len(keys), err)
+	}
+	verifier := &Verifier{Mode: VerifySignatures, SSHKeys: keys}
+
+	payload := "tree abc\ncommitter Dev <dev@example.com> 0 +0000\n\nFix it\n"
+	verification := func(payload, signature string) *github.SignatureVerification {
+		return &github.SignatureVerification{Payload: &payload, Signature: &signature}
+	}
+	if err := verifier.trusted(verification(payload, sshSign(t, signers[0], payload))); err != nil {
+		t.Errorf("expected a trusted signature, got %v", err)
+	}
+	cases := map[string]*github.SignatureVerification{
+		"untrusted key": verification(payload, sshSign(t, signers[1], payload)),
+		"tampered":      verification(payload+"more", sshSign(t, signers[0], payload)),
+		"gpg":           verification(payload, "-----BEGIN PGP SIGNATURE-----\n"),
+	}
+	for name, v := range cases {
+		if err := verifier.trusted(v); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSignatureModeNeedsKeys(t *testing.T) {
+	if _, err := NewVerifier(Config{VerifySources: VerifySignatures}); err == nil {
+		t.Error("expected signature mode without trusted keys to be refused")
+	}
+}
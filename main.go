@@ -1,19 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"html/template"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	a "github.com/jmcarp/deploy-to-cf/actions"
 	. "github.com/jmcarp/deploy-to-cf/helpers"
 
 	"github.com/gorilla/csrf"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/oauth2"
 )
 
@@ -22,18 +26,32 @@ func main() {
 	if err := envconfig.Process("", &config); err != nil {
 		log.Fatalf("Invalid configuration: %s", err.Error())
 	}
-	store := sessions.NewFilesystemStore(os.TempDir(), []byte(config.SecretKey))
-	store.MaxLength(8192)
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %s", err.Error())
+	}
+	// Outside Cloud Foundry there's no instance GUID, but each run is a new instance.
+	if config.Instance == "" {
+		config.Instance = NewID()
+	}
+	logger, err := ConfigureLogging(config)
+	if err != nil {
+		log.Fatalf("Invalid log level: %s", err.Error())
+	}
+	store, err := NewStore(config)
+	if err != nil {
+		log.Fatalf("Error creating session store: %s", err.Error())
+	}
 	templates := template.Must(template.ParseFiles("templates/index.html"))
-	oauthConfig := &oauth2.Config{
-		ClientID:     config.ClientID,
-		ClientSecret: config.ClientSecret,
-		RedirectURL:  config.Hostname + "/callback",
-		Scopes:       []string{"cloud_controller.read", "cloud_controller.write", "cloud_controller.admin", "openid"},
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  config.AuthURL + "/oauth/authorize",
-			TokenURL: config.TokenURL + "/oauth/token",
-		},
+	foundations, err := LoadFoundations(config)
+	if err != nil {
+		log.Fatalf("Invalid foundations: %s", err.Error())
+	}
+	discoveryClient := InstrumentedClient("cc", 10*time.Second)
+	for idx := range foundations {
+		foundations[idx], err = DiscoverEndpoints(discoveryClient, foundations[idx])
+		if err != nil {
+			log.Fatalf("Invalid foundation: %s", err.Error())
+		}
 	}
 
 	if config.ButtonLogo != "" {
@@ -43,26 +61,159 @@ func main() {
 		}
 	}
 
+	var policy *Policy
+	if config.PolicyFile != "" {
+		policy, err = LoadPolicy(config.PolicyFile)
+		if err != nil {
+			log.Fatalf("Error loading policy: %s", err.Error())
+		}
+	}
+
+	verifier, err := NewVerifier(config)
+	if err != nil {
+		log.Fatalf("Error loading trusted keys: %s", err.Error())
+	}
+
+	auditor, err := NewAuditor(config)
+	if err != nil {
+		log.Fatalf("Error opening audit sink: %s", err.Error())
+	}
+
+	cache, err := NewCache(config)
+	if err != nil {
+		log.Fatalf("Error creating cache: %s", err.Error())
+	}
+
+	deployments, err := NewDeploymentStore(config)
+	if err != nil {
+		log.Fatalf("Error creating deployment store: %s", err.Error())
+	}
+
 	gob.Register(oauth2.Token{})
+	gob.Register(map[string]Login{})
+	gob.Register([]HistoryEntry{})
 
 	ctx := &Context{
 		Config:      config,
 		Store:       store,
-		OauthConfig: oauthConfig,
+		Foundations: foundations,
+		Cache:       cache,
+		Policy:      policy,
+		Verifier:    verifier,
+		Audit:       auditor,
+		Deployments: deployments,
+		Queue:       NewDeployQueue(config.DeployConcurrency, config.DeployQueue),
+		Targets:     NewTargetCache(time.Duration(config.TargetCacheTTL) * time.Second),
+		Refs:        NewRefCache(time.Minute),
 		Templates:   templates,
+		Logger:      logger,
 	}
 
+	go watchDeployments(ctx)
+
 	r := mux.NewRouter()
 
 	r.Path("/auth").Handler(Contextify(ctx, Auth))
 	r.Path("/callback").Handler(Contextify(ctx, Callback))
+	r.Path("/logout").Methods("POST").Handler(Contextify(ctx, Logout))
+	r.Path("/healthz").Methods("GET").Handler(Contextify(ctx, a.Healthz))
+	r.Path("/readyz").Methods("GET").Handler(Contextify(ctx, a.Readyz))
+	r.Path("/foundations").Methods("GET").Handler(Contextify(ctx, a.Foundations))
 
 	r.Path("/").Methods("GET").Handler(RequireAuth(ctx, Contextify(ctx, a.Index)))
+	r.Path("/history").Methods("GET").Handler(RequireAuth(ctx, Contextify(ctx, a.History)))
+	r.Path("/apps").Methods("GET").Handler(RequireAuth(ctx, Contextify(ctx, a.Apps)))
+	r.Path("/targets").Methods("GET").Handler(RequireAuth(ctx, Contextify(ctx, a.Targets)))
 	r.Path("/").Methods("POST").Handler(RequireAuth(ctx, Contextify(ctx, a.Deploy)))
+	r.Path("/deployments/{id}").Methods("GET").Handler(RequireAuth(ctx, Contextify(ctx, a.DeploymentStatus)))
+
+	r.Path("/button.{format:svg|png}").Methods("GET").Handler(Contextify(ctx, a.Badge))
+	r.Path("/button").Methods("GET").Handler(Contextify(ctx, a.Button))
+
+	metrics := RequireBasicAuth(config.MetricsUsername, config.MetricsPassword, promhttp.Handler())
+	if config.MetricsPort != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics)
+			log.Fatal(http.ListenAndServe(":"+config.MetricsPort, mux))
+		}()
+	} else {
+		r.Path("/metrics").Methods("GET").Handler(metrics)
+	}
 
 	r.PathPrefix("/static").Handler(http.StripPrefix("/static", http.FileServer(http.Dir("./static"))))
 
 	p := csrf.Protect([]byte(config.SecretKey), csrf.Secure(config.SecureCookies))
-	log.Println("Listening")
-	http.ListenAndServe(":"+config.Port, p(r))
+	server := &http.Server{
+		Addr:    ":" + config.Port,
+		Handler: ctx.LogRequests(p(r)),
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Error serving: %s", err.Error())
+		}
+	}()
+	log.WithField("port", config.Port).Info("Listening")
+
+	// Stop accepting requests on SIGTERM, but let queued and running
+	// deployments finish within the drain timeout.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+	log.WithField("timeout", config.DrainTimeout).Info("Draining")
+
+	drain, cancel := context.WithTimeout(context.Background(), time.Duration(config.DrainTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(drain); err != nil {
+		log.WithError(err).Warn("Error stopping server")
+	}
+	if err := ctx.Queue.Drain(drain); err != nil {
+		log.WithError(err).Warn("Deployments still running at shutdown")
+		interruptDeployments(ctx, ctx.Config.Instance)
+	}
+	log.Info("Stopped")
+}
+
+// watchDeployments keeps this instance's deployments leased to it, fails
+// those of instances that stopped without finishing theirs, and prunes old
+// ones. During a rolling restart the old instance keeps its lease until it
+// has drained.
+func watchDeployments(ctx *Context) {
+	lease := time.Duration(ctx.Config.DeploymentLease) * time.Second
+	retention := time.Duration(ctx.Config.DeploymentRetention) * 24 * time.Hour
+	for {
+		if err := ctx.Deployments.Heartbeat(ctx.Config.Instance); err != nil {
+			log.WithError(err).Warn("Error sending heartbeat")
+		}
+		interruptDeployments(ctx, "")
+		if err := ctx.Deployments.Prune(time.Now().Add(-retention)); err != nil {
+			log.WithError(err).Warn("Error pruning deployments")
+		}
+		time.Sleep(lease / 3)
+	}
+}
+
+// interruptDeployments fails the deployments of stopped instances, and of
+// the stopped one given, if any.
+func interruptDeployments(ctx *Context, stopped string) {
+	interrupted, err := InterruptDeployments(ctx.Deployments, stopped, time.Duration(ctx.Config.DeploymentLease)*time.Second)
+	if err != nil {
+		log.WithError(err).Error("Error marking interrupted deployments")
+		return
+	}
+	for _, deployment := range interrupted {
+		log.WithField("deployment_id", deployment.ID).Warn(deployment.Error)
+		ctx.Audit.Record(AuditEvent{
+			Type:       AuditDeployFailed,
+			Deployment: deployment.ID,
+			UserID:     deployment.UserID,
+			Foundation: deployment.Foundation,
+			Source:     deployment.Owner + "/" + deployment.Repo,
+			Ref:        deployment.Ref,
+			SHA:        deployment.SHA,
+			OrgGUID:    deployment.OrgGUID,
+			SpaceGUID:  deployment.SpaceGUID,
+			Detail:     deployment.Error,
+		})
+	}
 }